@@ -0,0 +1,245 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edwingeng/hotswap"
+)
+
+type Handler struct {
+	swapper    *hotswap.PluginManagerSwapper
+	token      string
+	reloadData func() interface{}
+	mux        *http.ServeMux
+}
+
+type Option func(h *Handler)
+
+// WithToken sets the bearer token required by POST /reload. Reloading is disabled without a token.
+func WithToken(token string) Option {
+	return func(h *Handler) {
+		h.token = token
+	}
+}
+
+// WithReloadData sets the function providing the data passed to PluginManagerSwapper.Reload.
+func WithReloadData(fn func() interface{}) Option {
+	return func(h *Handler) {
+		h.reloadData = fn
+	}
+}
+
+// NewHandler creates an http.Handler serving GET /, GET /plugins, GET /vault and POST /reload.
+// POST /reload answers with the Details of the reload. Use http.StripPrefix to mount it under
+// a sub-path.
+func NewHandler(swapper *hotswap.PluginManagerSwapper, opts ...Option) *Handler {
+	h := &Handler{
+		swapper: swapper,
+		mux:     http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("/", h.serveStatus)
+	h.mux.HandleFunc("/plugins", h.servePlugins)
+	h.mux.HandleFunc("/vault", h.serveVault)
+	h.mux.HandleFunc("/reload", h.serveReload)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+type Status struct {
+	ReloadCounter     int64
+	StaticLinkingMode bool
+	When              time.Time `json:",omitempty"`
+	NumPlugins        int
+}
+
+type Plugin struct {
+	Name     string
+	File     string
+	Version  string `json:",omitempty"`
+	FileSha1 string
+	When     time.Time
+	Note     string
+	Deps     []string
+	Refs     int64
+
+	Health      *Health `json:",omitempty"`
+	Quarantined bool    `json:",omitempty"`
+}
+
+// Health is the result of the latest health check of a plugin.
+type Health struct {
+	Healthy  bool
+	Err      string `json:",omitempty"`
+	When     time.Time
+	Duration time.Duration
+}
+
+type LiveFunc struct {
+	Name      string
+	Signature string
+}
+
+type Vault struct {
+	LiveFuncs []LiveFunc
+	LiveTypes []string
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	st := Status{
+		ReloadCounter:     h.swapper.ReloadCounter(),
+		StaticLinkingMode: h.swapper.StaticLinkingMode(),
+	}
+	if pm := h.swapper.Current(); pm != nil {
+		st.NumPlugins = len(pm.Plugins())
+		if report := pm.Report(); report != nil {
+			st.When = report.When
+		}
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *Handler) servePlugins(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	a := make([]Plugin, 0)
+	if pm := h.swapper.Current(); pm != nil {
+		for _, p := range pm.Plugins() {
+			v := Plugin{
+				Name:    p.Name,
+				File:    p.File,
+				Version: p.Version,
+				When:    p.When,
+				Note:    p.Note,
+				Deps:    p.Deps,
+				Refs:    p.Refs.Load(),
+
+				Quarantined: p.Quarantined(),
+			}
+			if hs, ok := p.HealthStatus(); ok {
+				v.Health = &Health{
+					Healthy:  hs.Err == nil,
+					When:     hs.When,
+					Duration: hs.Duration,
+				}
+				if hs.Err != nil {
+					v.Health.Err = hs.Err.Error()
+				}
+			}
+			if p.File != "" {
+				v.FileSha1 = hex.EncodeToString(p.FileSha1[:])
+			}
+			a = append(a, v)
+		}
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *Handler) serveVault(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	v := Vault{
+		LiveFuncs: make([]LiveFunc, 0),
+		LiveTypes: make([]string, 0),
+	}
+	if pm := h.swapper.Current(); pm != nil {
+		for k, fn := range pm.LiveFuncs {
+			v.LiveFuncs = append(v.LiveFuncs, LiveFunc{
+				Name:      k,
+				Signature: reflect.TypeOf(fn).String(),
+			})
+		}
+		for k := range pm.LiveTypes {
+			v.LiveTypes = append(v.LiveTypes, k)
+		}
+	}
+	sort.Slice(v.LiveFuncs, func(i, j int) bool {
+		return v.LiveFuncs[i].Name < v.LiveFuncs[j].Name
+	})
+	sort.Strings(v.LiveTypes)
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return false
+	}
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(h.token)) == 1
+}
+
+func (h *Handler) serveReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if h.token == "" {
+		writeError(w, http.StatusForbidden, "reloading is disabled")
+		return
+	}
+	if !h.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var data interface{}
+	if h.reloadData != nil {
+		data = h.reloadData()
+	}
+	details, err := h.swapper.Reload(data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if details == nil {
+		writeError(w, http.StatusNotFound, "no plugin is found")
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
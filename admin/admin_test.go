@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/edwingeng/hotswap"
+	"github.com/edwingeng/slog"
+)
+
+func newTestHandler(t *testing.T, opts ...Option) *Handler {
+	t.Helper()
+	swapper := hotswap.NewPluginManagerSwapper("",
+		hotswap.WithLogger(slog.NewScavenger()),
+		hotswap.WithStaticPlugins(map[string]*hotswap.StaticPlugin{
+			"alpha": hotswap.NewStaticPlugin("alpha", hotswap.WithHotswapLiveFuncs(func() map[string]interface{} {
+				return map[string]interface{}{
+					"live_Hello": func(name string) string { return "hello " + name },
+				}
+			})),
+			"beta": hotswap.NewStaticPlugin("beta", hotswap.WithHealth(func() error {
+				return errors.New("unhealthy")
+			})),
+		}),
+	)
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(swapper, opts...)
+}
+
+func serve(h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	h := newTestHandler(t, WithToken("secret"))
+
+	rec1 := serve(h, http.MethodGet, "/", "")
+	var st Status
+	if err := json.Unmarshal(rec1.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if !st.StaticLinkingMode || st.NumPlugins != 2 || st.ReloadCounter != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	if err := h.swapper.Current().CheckHealth(context.Background()); err == nil {
+		t.Fatal("CheckHealth should fail")
+	}
+	rec2 := serve(h, http.MethodGet, "/plugins", "")
+	var plugins []Plugin
+	if err := json.Unmarshal(rec2.Body.Bytes(), &plugins); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	if len(plugins) != 2 || plugins[0].Name != "alpha" || plugins[0].Refs != 1 {
+		t.Fatalf("unexpected plugins: %+v", plugins)
+	}
+	if plugins[0].Health != nil || plugins[1].Health == nil || plugins[1].Health.Healthy || plugins[1].Health.Err != "unhealthy" {
+		t.Fatalf("unexpected health: %+v", plugins)
+	}
+
+	rec3 := serve(h, http.MethodGet, "/vault", "")
+	var v Vault
+	if err := json.Unmarshal(rec3.Body.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.LiveFuncs) != 1 || v.LiveFuncs[0].Signature != "func(string) string" {
+		t.Fatalf("unexpected vault: %+v", v)
+	}
+
+	if rec := serve(h, http.MethodGet, "/reload", "secret"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if rec := serve(h, http.MethodPost, "/reload", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if rec := serve(h, http.MethodPost, "/reload", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if rec := serve(h, http.MethodPost, "/reload", "secret"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("reloading should fail under static linking mode. code: %d", rec.Code)
+	}
+	if rec := serve(h, http.MethodGet, "/unknown", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	noToken := newTestHandler(t)
+	if rec := serve(noToken, http.MethodPost, "/reload", "secret"); rec.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	empty := NewHandler(hotswap.NewPluginManagerSwapper(t.TempDir(), hotswap.WithLogger(slog.NewScavenger())),
+		WithToken("secret"))
+	if rec := serve(empty, http.MethodPost, "/reload", "secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+}
@@ -39,6 +39,8 @@ const (
 	hotswapBureauFile           = "hotswap.bureau.go"
 	hotswapMainFile             = "hotswap.main.go"
 	hotswapLiveFile             = "hotswap.live.go"
+	hotswapRPCFile              = "hotswap.rpc.go"
+	hotswapManifestFile         = "hotswap.manifest.go"
 	hotswapStaticPluginInitFile = "hotswap.staticPluginInit.%s.go"
 	hotswapStaticPluginsFile    = "hotswap.staticPlugins.go"
 )
@@ -47,8 +49,11 @@ var hotswapFiles = map[string]struct{}{
 	hotswapBureauFile: {},
 	hotswapMainFile:   {},
 	hotswapLiveFile:   {},
+	hotswapRPCFile:    {},
 }
 
+const rpcDirective = "//hotswap:rpc"
+
 var (
 	//go:embed hotswapBureau.tpl
 	tplHotswapBureau []byte
@@ -56,6 +61,10 @@ var (
 	tplHotswapMain string
 	//go:embed hotswapLive.tpl
 	tplHotswapLive string
+	//go:embed hotswapRPC.tpl
+	tplHotswapRPC string
+	//go:embed hotswapManifest.tpl
+	tplHotswapManifest string
 	//go:embed hotswapStaticPluginInit.tpl
 	tplHotswapStaticPluginInit string
 	//go:embed hotswapStaticPlugins.tpl
@@ -136,6 +145,8 @@ type buildCmdT struct {
 	tmpDirName    string
 	tmpDir        string
 	tmpPkgPath    string
+	commitHash    string
+	commitTime    time.Time
 
 	rexInclude *regexp.Regexp
 	rexExclude *regexp.Regexp
@@ -386,7 +397,9 @@ func (bc *buildCmdT) commitInfo() string {
 	if err != nil {
 		panic(fmt.Errorf("failed to parse the timestamp of the last git commit. str: %s, err: %v", output2, err))
 	}
-	t := time.Unix(int64(n), 0).UTC().Format(hutils.CompactDateTimeFormat)
+	bc.commitHash = string(output1)
+	bc.commitTime = time.Unix(int64(n), 0).UTC()
+	t := bc.commitTime.Format(hutils.CompactDateTimeFormat)
 	return fmt.Sprintf("%s-%s", t, output1[:8])
 }
 
@@ -507,21 +520,38 @@ func removeStaticFiles(args completePluginArgs) {
 }
 
 type pluginFunc struct {
-	Name string
-	Expr string
+	Name     string
+	Expr     string
+	Optional bool
 }
 
 func parseRequiredPluginFuncs(pluginDir, pluginPkgName string) []pluginFunc {
 	pluginFuncMap := map[string]string{
-		"OnLoad":           "nil",
-		"OnInit":           "nil",
-		"OnFree":           "nil",
-		"Export":           "nil",
-		"Import":           "nil",
-		"InvokeFunc":       "nil",
-		"Reloadable":       "nil",
-		"HotswapLiveFuncs": "nil",
-		"HotswapLiveTypes": "nil",
+		"OnLoad":            "nil",
+		"OnInit":            "nil",
+		"OnFree":            "nil",
+		"Export":            "nil",
+		"Import":            "nil",
+		"InvokeFunc":        "nil",
+		"Reloadable":        "nil",
+		"HotswapLiveFuncs":  "nil",
+		"HotswapLiveTypes":  "nil",
+		"OnMigrate":         "nil",
+		"OnSnapshot":        "nil",
+		"OnLoadContext":     "nil",
+		"OnInitContext":     "nil",
+		"InvokeFuncContext": "nil",
+		"HotswapMethods":    "nil",
+		"Health":            "nil",
+	}
+	optional := map[string]struct{}{
+		"Health":            {},
+		"HotswapMethods":    {},
+		"OnMigrate":         {},
+		"OnSnapshot":        {},
+		"OnLoadContext":     {},
+		"OnInitContext":     {},
+		"InvokeFuncContext": {},
 	}
 	if pluginPkgName == "" {
 		delete(pluginFuncMap, "HotswapLiveFuncs")
@@ -531,13 +561,21 @@ func parseRequiredPluginFuncs(pluginDir, pluginPkgName string) []pluginFunc {
 	var fset token.FileSet
 	pkgs, err := parser.ParseDir(&fset, pluginDir, func(info os.FileInfo) bool {
 		return !strings.HasSuffix(info.Name(), "_test.go")
-	}, 0)
+	}, parser.ParseComments)
 	if err != nil {
 		panic(err)
 	}
 	for _, pkg := range pkgs {
 		for _, file := range pkg.Files {
 			for _, decl := range file.Decls {
+				if genDecl, ok := decl.(*ast.GenDecl); ok {
+					if findRPCTypes(genDecl) != nil {
+						// InvokeFunc and HotswapMethods will be generated.
+						pluginFuncMap["InvokeFunc"] = fmt.Sprintf("%s.InvokeFunc", pluginPkgName)
+						pluginFuncMap["HotswapMethods"] = fmt.Sprintf("%s.HotswapMethods", pluginPkgName)
+					}
+					continue
+				}
 				funcDecl, ok := decl.(*ast.FuncDecl)
 				if !ok || funcDecl.Recv != nil {
 					continue
@@ -553,7 +591,11 @@ func parseRequiredPluginFuncs(pluginDir, pluginPkgName string) []pluginFunc {
 	var a []string
 	var missing []string
 	for k, v := range pluginFuncMap {
-		if v == "nil" {
+		if _, ok := optional[k]; ok {
+			if v != "nil" {
+				a = append(a, k)
+			}
+		} else if v == "nil" {
 			missing = append(missing, k)
 		} else {
 			a = append(a, k)
@@ -567,9 +609,11 @@ func parseRequiredPluginFuncs(pluginDir, pluginPkgName string) []pluginFunc {
 
 	var ret []pluginFunc
 	for _, k := range a {
+		_, ok := optional[k]
 		ret = append(ret, pluginFunc{
-			Name: k,
-			Expr: pluginFuncMap[k],
+			Name:     k,
+			Expr:     pluginFuncMap[k],
+			Optional: ok,
 		})
 	}
 	return ret
@@ -879,6 +923,8 @@ type completePluginArgs struct {
 	tmpDirName    string
 	tmpDir        string
 	tmpPkgPath    string
+	commitHash    string
+	commitTime    time.Time
 	epilogue      func(completePluginArgs, *generatedFiles)
 }
 
@@ -896,6 +942,8 @@ func buildCompletePluginArgs(cmd *buildCmdT, gofmt, clean bool, epilogue func(co
 		tmpDirName:    cmd.tmpDirName,
 		tmpDir:        cmd.tmpDir,
 		tmpPkgPath:    cmd.tmpPkgPath,
+		commitHash:    cmd.commitHash,
+		commitTime:    cmd.commitTime,
 	}
 	if epilogue != nil {
 		args.epilogue = epilogue
@@ -923,6 +971,17 @@ func genHotswapBureau(args completePluginArgs, generated *generatedFiles) {
 	generated.add(file, false)
 }
 
+func rootPackageName(args completePluginArgs) string {
+	if !args.staticLinking {
+		return "main"
+	}
+	pkgName, _, err := hutils.PackageFromDirectory(args.tmpDir)
+	if err != nil {
+		panic(err)
+	}
+	return pkgName
+}
+
 func genHotswapMain(args completePluginArgs, livePackages map[string]*packages.Package, generated *generatedFiles) {
 	var a []string
 	for _, pkg := range livePackages {
@@ -932,14 +991,7 @@ func genHotswapMain(args completePluginArgs, livePackages map[string]*packages.P
 	}
 	sort.Strings(a)
 
-	pkgName := "main"
-	if args.staticLinking {
-		var err error
-		pkgName, _, err = hutils.PackageFromDirectory(args.tmpDir)
-		if err != nil {
-			panic(err)
-		}
-	}
+	pkgName := rootPackageName(args)
 
 	tpl := template.Must(template.New("hotswapMain").Parse(tplHotswapMain))
 	tplArgs := struct {
@@ -1000,6 +1052,177 @@ func genHotswapLive(args completePluginArgs, dir string, pkg *packages.Package,
 	generated.add(file, false)
 }
 
+func genHotswapRPC(args completePluginArgs, typeName string, methods []string, generated *generatedFiles) {
+	tpl := template.Must(template.New("hotswapRPC").Parse(tplHotswapRPC))
+	tplArgs := struct {
+		PackageName string
+		TypeName    string
+		Methods     []string
+	}{
+		PackageName: rootPackageName(args),
+		TypeName:    typeName,
+		Methods:     methods,
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, &tplArgs); err != nil {
+		panic(err)
+	}
+	file := filepath.Join(args.tmpDir, hotswapRPCFile)
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+	if args.gofmt {
+		if err := hutils.Gofmt(file); err != nil {
+			panic(err)
+		}
+	}
+
+	generated.add(file, false)
+}
+
+func sharedDependencies(dir string) map[string]string {
+	const format = `{{with .Module}}{{if not .Main}}{{.Path}} ` +
+		`{{if .Replace}}{{.Replace.Version}}{{else}}{{.Version}}{{end}}{{end}}{{end}}`
+	cmd := exec.Command("go", "list", "-deps", "-f", format, ".")
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		panic(fmt.Errorf("failed to list the dependencies. err: %w", err))
+	}
+
+	deps := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			deps[fields[0]] = fields[1]
+		}
+	}
+	return deps
+}
+
+func goVersion() string {
+	output, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		panic(fmt.Errorf("failed to determine the go version. err: %w", err))
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func genHotswapManifest(args completePluginArgs, liveFuncs, liveTypes map[string][]string, generated *generatedFiles) {
+	m := &hutils.Manifest{
+		Plugin:     filepath.Base(args.pluginDir),
+		Commit:     args.commitHash,
+		CommitTime: args.commitTime,
+		BuildTime:  time.Now().UTC(),
+		GoVersion:  goVersion(),
+		Deps:       sharedDependencies(args.tmpDir),
+	}
+	for _, a := range liveFuncs {
+		m.LiveFuncs = append(m.LiveFuncs, a...)
+	}
+	for _, a := range liveTypes {
+		m.LiveTypes = append(m.LiveTypes, a...)
+	}
+	sort.Strings(m.LiveFuncs)
+	sort.Strings(m.LiveTypes)
+	str, err := hutils.EncodeManifest(m)
+	if err != nil {
+		panic(err)
+	}
+
+	tpl := template.Must(template.New("hotswapManifest").Parse(tplHotswapManifest))
+	tplArgs := struct {
+		PackageName string
+		Manifest    string
+	}{
+		PackageName: rootPackageName(args),
+		Manifest:    strconv.Quote(str),
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, &tplArgs); err != nil {
+		panic(err)
+	}
+	file := filepath.Join(args.tmpDir, hotswapManifestFile)
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+
+	generated.add(file, false)
+}
+
+func findRPCTypes(genDecl *ast.GenDecl) []string {
+	if genDecl.Tok != token.TYPE {
+		return nil
+	}
+	hasDirective := func(doc *ast.CommentGroup) bool {
+		if doc == nil {
+			return false
+		}
+		for _, c := range doc.List {
+			if strings.TrimSpace(c.Text) == rpcDirective {
+				return true
+			}
+		}
+		return false
+	}
+	var a []string
+	for _, spec := range genDecl.Specs {
+		typeSpec, ok := spec.(*ast.TypeSpec)
+		if !ok {
+			continue
+		}
+		if hasDirective(typeSpec.Doc) || len(genDecl.Specs) == 1 && hasDirective(genDecl.Doc) {
+			a = append(a, typeSpec.Name.Name)
+		}
+	}
+	return a
+}
+
+// dispatchable reports whether rpc.Dispatch can call the method, that is, whether it returns
+// nothing, a value, an error, or a value and an error.
+func dispatchable(funcDecl *ast.FuncDecl) bool {
+	results := funcDecl.Type.Results
+	if results == nil {
+		return true
+	}
+	var types []ast.Expr
+	for _, field := range results.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, field.Type)
+		}
+	}
+	switch len(types) {
+	case 0, 1:
+		return true
+	case 2:
+		ident, ok := types[1].(*ast.Ident)
+		return ok && ident.Name == "error"
+	default:
+		return false
+	}
+}
+
+func receiverTypeName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return ""
+	}
+	expr := funcDecl.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
 func completePlugin(args completePluginArgs) {
 	timing.processPackagesStart = time.Now()
 	defer func() {
@@ -1012,6 +1235,7 @@ func completePlugin(args completePluginArgs) {
 
 	var cfg packages.Config
 	cfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax
+	cfg.Fset = token.NewFileSet()
 	pkgs, err := packages.Load(&cfg, args.tmpPkgPath+"/...")
 	if err != nil {
 		panic(err)
@@ -1025,9 +1249,14 @@ func completePlugin(args completePluginArgs) {
 	liveTypes := make(map[string][]string)
 	livePackages := make(map[string]*packages.Package)
 
+	var rpcTypes []string
+	var rpcMethods []string
+	var rpcConflicts []string
+	rpcUndispatchable := make(map[string]struct{})
+
 	var numErrs int
 	printErrorMessage := func(str string) {
-		_, _ = os.Stderr.WriteString("Error: " + str)
+		_, _ = os.Stderr.WriteString("Error: " + str + "\n")
 		numErrs++
 	}
 
@@ -1041,9 +1270,27 @@ func completePlugin(args completePluginArgs) {
 		default:
 		}
 		dir := filepath.Dir(pkg.GoFiles[0])
+		isRoot := pkg.PkgPath == args.tmpPkgPath
 		for _, synt := range pkg.Syntax {
+			if isRoot && filepath.Base(cfg.Fset.Position(synt.Pos()).Filename) == hotswapRPCFile {
+				continue
+			}
 			for _, decl := range synt.Decls {
 				if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+					if isRoot {
+						switch {
+						case funcDecl.Recv != nil:
+							if ast.IsExported(funcDecl.Name.Name) {
+								m := receiverTypeName(funcDecl) + "." + funcDecl.Name.Name
+								rpcMethods = append(rpcMethods, m)
+								if !dispatchable(funcDecl) {
+									rpcUndispatchable[m] = struct{}{}
+								}
+							}
+						case funcDecl.Name.Name == "InvokeFunc" || funcDecl.Name.Name == "HotswapMethods":
+							rpcConflicts = append(rpcConflicts, funcDecl.Name.Name)
+						}
+					}
 					if funcDecl.Recv != nil {
 						continue
 					}
@@ -1063,6 +1310,9 @@ func completePlugin(args completePluginArgs) {
 					if genDecl.Tok != token.TYPE {
 						continue
 					}
+					if isRoot {
+						rpcTypes = append(rpcTypes, findRPCTypes(genDecl)...)
+					}
 					for _, spec := range genDecl.Specs {
 						typeSpec, ok := spec.(*ast.TypeSpec)
 						if !ok {
@@ -1090,6 +1340,29 @@ func completePlugin(args completePluginArgs) {
 			}
 		}
 	}
+
+	var methods []string
+	switch len(rpcTypes) {
+	case 0:
+	case 1:
+		for _, m := range rpcMethods {
+			if !strings.HasPrefix(m, rpcTypes[0]+".") {
+				continue
+			}
+			if _, ok := rpcUndispatchable[m]; ok {
+				printErrorMessage(fmt.Sprintf("%s must return nothing, a value, an error, or a value and an error", m))
+				continue
+			}
+			methods = append(methods, strings.TrimPrefix(m, rpcTypes[0]+"."))
+		}
+		sort.Strings(methods)
+		for _, fn := range rpcConflicts {
+			printErrorMessage(fmt.Sprintf("%s must not be defined when a type is tagged with %s", fn, rpcDirective))
+		}
+	default:
+		sort.Strings(rpcTypes)
+		printErrorMessage(fmt.Sprintf("only one type can be tagged with %s: %s", rpcDirective, hutils.Join(rpcTypes...)))
+	}
 	if numErrs > 0 {
 		panic(fmt.Errorf("%d errors occurred", numErrs))
 	}
@@ -1130,6 +1403,12 @@ func completePlugin(args completePluginArgs) {
 	generated.files = make(map[string]bool)
 	genHotswapBureau(args, &generated)
 	genHotswapMain(args, livePackages, &generated)
+	if len(rpcTypes) == 1 {
+		genHotswapRPC(args, rpcTypes[0], methods, &generated)
+	}
+	if !args.staticLinking {
+		genHotswapManifest(args, liveFuncs, liveTypes, &generated)
+	}
 
 	for k, pkg := range livePackages {
 		select {
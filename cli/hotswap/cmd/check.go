@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/edwingeng/hotswap/internal/hutils"
+	"github.com/spf13/cobra"
+)
+
+var checkCmd checkCmdT
+
+const (
+	checkExamples = `hotswap check bin/server bin/plugins/foo.so
+hotswap check bin/server bin/plugins/*.so`
+)
+
+var checkCmdCobra = &cobra.Command{
+	Use:     "check [flags] <hostBinary> <plugin.so>...",
+	Short:   "Check whether plugins can be opened by a host binary",
+	Example: checkExamples,
+	Run:     checkCmd.execute,
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmdCobra)
+	cmd := checkCmdCobra
+	cmd.Flags().BoolVarP(&checkCmd.verbose,
+		"verbose", "v", false, "print the package hashes of mismatched packages")
+}
+
+type checkCmdT struct {
+	verbose bool
+}
+
+func (cc *checkCmdT) execute(cmd *cobra.Command, args []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			_, _ = os.Stderr.WriteString(fmt.Sprintln(r))
+			os.Exit(1)
+		}
+	}()
+
+	if len(args) < 2 {
+		_, _ = os.Stderr.WriteString(cmd.UsageString())
+		os.Exit(1)
+	}
+
+	var failed bool
+	for _, file := range args[1:] {
+		r, err := hutils.CheckCompatibility(args[0], file)
+		if err != nil {
+			panic(err)
+		}
+		if r.OK() {
+			fmt.Printf("%s: OK\n", file)
+			continue
+		}
+		failed = true
+		fmt.Printf("%s: INCOMPATIBLE\n", file)
+		if r.PluginGoVersion != r.HostGoVersion {
+			fmt.Printf("    go: %s (host: %s)\n", r.PluginGoVersion, r.HostGoVersion)
+		}
+		for _, m := range r.Mismatches {
+			if !cc.verbose {
+				if m.PluginVersion == m.HostVersion {
+					fmt.Printf("    %s %s: the package hash differs, built with different flags or sources\n",
+						m.Package, m.PluginVersion)
+					continue
+				}
+				m.PluginHash, m.HostHash = "", ""
+			}
+			fmt.Printf("    %s\n", m)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
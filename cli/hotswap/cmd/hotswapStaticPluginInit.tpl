@@ -11,9 +11,12 @@ func init() {
 	HotswapStaticPlugins["{{.PluginName}}"] = &hotswap.StaticPlugin {
 		Name: "{{.PluginName}}",
 		PluginFuncs: hotswap.NewPluginFuncs(
-	{{- range .PluginFuncs}}
+	{{- range .PluginFuncs}}{{if not .Optional}}
 			{{.Expr}},
-	{{- end}}
+	{{- end}}{{end}}
+	{{- range .PluginFuncs}}{{if .Optional}}
+			hotswap.With{{.Name}}({{.Expr}}),
+	{{- end}}{{end}}
 		),
 	}
 }
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/edwingeng/hotswap/internal/hutils"
+	"github.com/spf13/cobra"
+)
+
+var signCmd signCmdT
+
+const (
+	signExamples = `hotswap sign --newKey hotswap.key
+hotswap sign --key hotswap.key bin/foo.so bin/bar.so
+hotswap sign --key hotswap.key --versioned bin/foo/1.2.0.so
+hotswap sign --key hotswap.key --name foo bin/objects/<sha256>`
+)
+
+var signCmdCobra = &cobra.Command{
+	Use:     "sign [flags] <plugin.so>...",
+	Short:   "Sign plugins with an ed25519 private key",
+	Example: signExamples,
+	Run:     signCmd.execute,
+}
+
+func init() {
+	rootCmd.AddCommand(signCmdCobra)
+	cmd := signCmdCobra
+	cmd.Flags().StringVar(&signCmd.keyFile,
+		"key", "", "the file holding the base64-encoded private key")
+	cmd.Flags().StringVar(&signCmd.newKeyFile,
+		"newKey", "", "generate a new private key into the file and print its public key")
+	cmd.Flags().BoolVar(&signCmd.versioned,
+		"versioned", false, "the plugins are in the layout <dir>/<name>/<version>.so")
+	cmd.Flags().StringVar(&signCmd.name,
+		"name", "", "the plugin name, if it cannot be derived from the file name")
+}
+
+type signCmdT struct {
+	keyFile    string
+	newKeyFile string
+	versioned  bool
+	name       string
+}
+
+func (sc *signCmdT) execute(cmd *cobra.Command, args []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			_, _ = os.Stderr.WriteString(fmt.Sprintln(r))
+			os.Exit(1)
+		}
+	}()
+
+	if sc.newKeyFile != "" {
+		sc.newKey()
+		if len(args) == 0 {
+			return
+		}
+		sc.keyFile = sc.newKeyFile
+	}
+	if sc.keyFile == "" || len(args) == 0 || sc.name != "" && len(args) > 1 {
+		_, _ = os.Stderr.WriteString(cmd.UsageString())
+		os.Exit(1)
+	}
+
+	keyData, err := ioutil.ReadFile(sc.keyFile)
+	if err != nil {
+		panic(err)
+	}
+	key, err := hutils.ParsePrivateKey(keyData)
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range args {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		sigFile := hutils.SignatureFile(file)
+		name, version := sc.nameAndVersion(file)
+		if err := ioutil.WriteFile(sigFile, hutils.SignPlugin(key, name, version, data), 0644); err != nil {
+			panic(err)
+		}
+		fmt.Println(sigFile)
+	}
+}
+
+// nameAndVersion returns the plugin name and version the same way the plugin source lists
+// them, because both are covered by the signature.
+func (sc *signCmdT) nameAndVersion(file string) (string, string) {
+	base := strings.TrimSuffix(filepath.Base(file), hutils.FileNameExt)
+	switch {
+	case sc.versioned:
+		name := sc.name
+		if name == "" {
+			name = filepath.Base(filepath.Dir(file))
+		}
+		return name, base
+	case sc.name != "":
+		return sc.name, ""
+	default:
+		return base, ""
+	}
+}
+
+func (sc *signCmdT) newKey() {
+	if _, err := os.Stat(sc.newKeyFile); err == nil {
+		panic(fmt.Errorf("%s already exists", sc.newKeyFile))
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	seed := base64.StdEncoding.EncodeToString(priv.Seed()) + "\n"
+	if err := ioutil.WriteFile(sc.newKeyFile, []byte(seed), 0600); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Public Key: %s\n", base64.StdEncoding.EncodeToString(pub))
+}
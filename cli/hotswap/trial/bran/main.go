@@ -0,0 +1,54 @@
+package bran
+
+import (
+	"errors"
+
+	"github.com/edwingeng/hotswap/vault"
+)
+
+func OnLoad(data interface{}) error {
+	return nil
+}
+
+func OnInit(sharedVault *vault.Vault) error {
+	return nil
+}
+
+func OnFree() {
+	// NOP
+}
+
+func Export() interface{} {
+	return nil
+}
+
+func Import() interface{} {
+	return nil
+}
+
+func Reloadable() bool {
+	return true
+}
+
+//hotswap:rpc
+type Raven struct{}
+
+func (r *Raven) Greet(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("who are you?")
+	}
+	return "Hodor, " + name, nil
+}
+
+func (Raven) Sum(a int, b ...int) int {
+	for _, v := range b {
+		a += v
+	}
+	return a
+}
+
+func (Raven) Warg() {
+}
+
+func (Raven) dream() {
+}
@@ -7,6 +7,7 @@ import (
 
 var (
 	snowLog slog.Logger
+	state   int
 )
 
 func OnLoad(data interface{}) error {
@@ -31,6 +32,10 @@ func Import() interface{} {
 }
 
 func InvokeFunc(name string, params ...interface{}) (interface{}, error) {
+	if name == "bump" {
+		state++
+		return state, nil
+	}
 	return nil, nil
 }
 
@@ -41,3 +46,16 @@ func Reloadable() bool {
 type live_Longclaw struct {
 	// Empty
 }
+
+func OnMigrate(old interface{}) (interface{}, error) {
+	if old == nil {
+		state = 1
+	} else {
+		state = old.(int) + 1
+	}
+	return state, nil
+}
+
+func OnSnapshot() (interface{}, error) {
+	return state, nil
+}
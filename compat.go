@@ -0,0 +1,19 @@
+package hotswap
+
+import (
+	"github.com/edwingeng/hotswap/internal/hutils"
+)
+
+type (
+	// Mismatch describes a package or module whose version differs between a plugin and the host.
+	Mismatch = hutils.Mismatch
+	// CompatReport is the result of CheckCompatibility.
+	CompatReport = hutils.CompatReport
+)
+
+// CheckCompatibility compares the package hashes, the build info and the manifest of
+// pluginFile against hostFile without opening the plugin. It is what "hotswap check" runs.
+// Package hashes are only compared for ELF files.
+func CheckCompatibility(hostFile, pluginFile string) (*CompatReport, error) {
+	return hutils.CheckCompatibility(hostFile, pluginFile)
+}
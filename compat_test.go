@@ -0,0 +1,71 @@
+package hotswap
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	outputDir := preparePluginGroup(t, nil, "Compat", "arya")
+	host, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginFile := filepath.Join(outputDir, "arya.so")
+	r1, err := CheckCompatibility(host, pluginFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r1.HashesCompared || r1.Manifest == nil || r1.Manifest.Plugin != "arya" {
+		t.Fatalf("unexpected report: %+v", r1)
+	}
+	if err := r1.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(pluginFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.Open(pluginFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tampered bool
+	for _, sym := range syms {
+		if sym.Name == "go:link.pkghashbytes.go.uber.org/zap" {
+			sec := f.Sections[sym.Section]
+			data[sec.Offset+sym.Value-sec.Addr] ^= 0xff
+			tampered = true
+		}
+	}
+	_ = f.Close()
+	if !tampered {
+		t.Fatal("cannot find the package hash of go.uber.org/zap")
+	}
+	tamperedFile := filepath.Join(outputDir, "arya-tampered.so")
+	if err := os.WriteFile(tamperedFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tamperedFile)
+
+	r2, err := CheckCompatibility(host, tamperedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r2.Mismatches) != 1 || r2.Mismatches[0].Package != "go.uber.org/zap" ||
+		!strings.HasPrefix(r2.Mismatches[0].PluginVersion, "v1.") {
+		t.Fatalf("unexpected mismatches: %+v", r2.Mismatches)
+	}
+	if err := r2.Err(); err == nil || !strings.Contains(err.Error(), "mismatched packages: go.uber.org/zap v1.") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
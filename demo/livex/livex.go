@@ -121,5 +121,10 @@ func heartbeat() {
 	case 1:
 		action = "Fire"
 	}
-	_, _ = g.PluginManagerSwapper.Current().FindPlugin("guardian").InvokeFunc(action)
+	fn, err := hotswap.Method[func()](g.PluginManagerSwapper.Current().FindPlugin("guardian"), action)
+	if err != nil {
+		g.Logger.Error(err)
+		return
+	}
+	fn()
 }
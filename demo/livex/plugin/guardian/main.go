@@ -41,36 +41,44 @@ func Import() interface{} {
 	return nil
 }
 
-func InvokeFunc(name string, params ...interface{}) (interface{}, error) {
-	switch name {
-	case "MakeRollCall":
-		job.MakeRollCall(pluginName, CompileTimeString)
-	case "Fire":
-		job.Fire(pluginName, CompileTimeString)
-	default:
-		panic("impossible")
-	}
-	return nil, nil
-}
-
 func Reloadable() bool {
 	return true
 }
 
+// Guardian's exported methods are invoked through the InvokeFunc generated by hotswap.
+//
+//hotswap:rpc
+type Guardian struct{}
+
+func (Guardian) MakeRollCall() {
+	job.MakeRollCall(pluginName, CompileTimeString)
+}
+
+func (Guardian) Fire() {
+	job.Fire(pluginName, CompileTimeString)
+}
+
 type JobHandler1 = func(pluginName string, compileTimeString string, jobData live.Data) error
 type JobHandler2 = interface {
 	Handle(pluginName string, compileTimeString string) error
 }
 
 func OnJob(job *tickque.Job) error {
-	if v, ok := pg.SharedVault.LiveFuncs[job.Type]; ok {
-		return v.(JobHandler1)(pluginName, CompileTimeString, job.Data)
+	if _, ok := pg.SharedVault.LiveFuncs[job.Type]; ok {
+		fn, err := vault.Func[JobHandler1](pg.SharedVault, job.Type)
+		if err != nil {
+			return err
+		}
+		return fn(pluginName, CompileTimeString, job.Data)
 	}
 
-	if f, ok := pg.SharedVault.LiveTypes[job.Type]; ok {
-		newObj := f()
+	if _, ok := pg.SharedVault.LiveTypes[job.Type]; ok {
+		newObj, err := vault.New[JobHandler2](pg.SharedVault, job.Type)
+		if err != nil {
+			return err
+		}
 		job.Data.MustUnwrapObject(newObj)
-		return newObj.(JobHandler2).Handle(pluginName, CompileTimeString)
+		return newObj.Handle(pluginName, CompileTimeString)
 	}
 
 	return fmt.Errorf("unknown job: %s", job.Type)
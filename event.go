@@ -0,0 +1,145 @@
+package hotswap
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type EventKind int
+
+const (
+	EventReloadStarted EventKind = iota + 1
+	EventFileHashed
+	EventPluginOpened
+	EventDepsWired
+	EventOnLoadInvoked
+	EventOnInitInvoked
+	EventReloadCommitted
+	EventReloadAborted
+	EventGenerationFreed
+	// EventHealthChecked is emitted for every plugin checked by PluginManager.CheckHealth,
+	// during a reload or not.
+	EventHealthChecked
+	// EventRolledBack is emitted when a reload is rolled back automatically during the
+	// rollback window. Err is the reason.
+	EventRolledBack
+	// EventRollbackVetoed is emitted when a ReloadHook vetoes a rollback, whether it is
+	// requested by Rollback or by the rollback window. Err is the veto.
+	EventRollbackVetoed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventReloadStarted:
+		return "ReloadStarted"
+	case EventFileHashed:
+		return "FileHashed"
+	case EventPluginOpened:
+		return "PluginOpened"
+	case EventDepsWired:
+		return "DepsWired"
+	case EventOnLoadInvoked:
+		return "OnLoadInvoked"
+	case EventOnInitInvoked:
+		return "OnInitInvoked"
+	case EventReloadCommitted:
+		return "ReloadCommitted"
+	case EventReloadAborted:
+		return "ReloadAborted"
+	case EventGenerationFreed:
+		return "GenerationFreed"
+	case EventHealthChecked:
+		return "HealthChecked"
+	case EventRolledBack:
+		return "RolledBack"
+	case EventRollbackVetoed:
+		return "RollbackVetoed"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event describes a step of a reload. Plugin is empty for the events concerning
+// a whole generation.
+type Event struct {
+	Kind     EventKind
+	Plugin   string
+	When     time.Time
+	Duration time.Duration
+	Err      error
+}
+
+type EventHandler func(ev Event)
+
+type eventBus struct {
+	mu       sync.Mutex
+	nextID   int
+	handlers map[int]EventHandler
+}
+
+func (eb *eventBus) subscribe(h EventHandler) func() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if eb.handlers == nil {
+		eb.handlers = make(map[int]EventHandler)
+	}
+	eb.nextID++
+	id := eb.nextID
+	eb.handlers[id] = h
+	return func() {
+		eb.mu.Lock()
+		delete(eb.handlers, id)
+		eb.mu.Unlock()
+	}
+}
+
+func (eb *eventBus) snapshot() []EventHandler {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	a := make([]EventHandler, 0, len(eb.handlers))
+	for _, h := range eb.handlers {
+		a = append(a, h)
+	}
+	return a
+}
+
+// Subscribe registers an EventHandler and returns a function to unregister it. Handlers
+// are called synchronously in the goroutine emitting the event, so they should return quickly.
+// Most events come from the reloading goroutine, but EventGenerationFreed comes from the
+// goroutine freeing the generation, EventHealthChecked may come from the health monitor, and
+// EventRolledBack and EventRollbackVetoed may come from the goroutine watching the rollback
+// window. Handlers must therefore be safe for concurrent use.
+func (sw *PluginManagerSwapper) Subscribe(h EventHandler) (unsubscribe func()) {
+	return sw.events.subscribe(h)
+}
+
+func (sw *PluginManagerSwapper) emit(kind EventKind, plugin string, start time.Time, err error) {
+	handlers := sw.events.snapshot()
+	if len(handlers) == 0 && sw.opts.metrics == nil {
+		return
+	}
+
+	now := time.Now()
+	ev := Event{
+		Kind:     kind,
+		Plugin:   plugin,
+		When:     now,
+		Duration: now.Sub(start),
+		Err:      err,
+	}
+	if sw.opts.metrics != nil {
+		sw.recordMetrics(ev)
+	}
+	for _, h := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					sw.Errorf("<hotswap> panic in the event handler: %+v\n%s", r, debug.Stack())
+				}
+			}()
+			h(ev)
+		}()
+	}
+}
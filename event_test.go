@@ -0,0 +1,69 @@
+package hotswap
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPluginManagerSwapper_Subscribe(t *testing.T) {
+	log := newScavenger()
+	plugins := newFakeStaticPlugins("alpha", "beta")
+	swapper := newSwapper("", WithLogger(log), WithStaticPlugins(plugins))
+
+	var events []Event
+	unsubscribe := swapper.Subscribe(func(ev Event) {
+		events = append(events, ev)
+	})
+	swapper.Subscribe(func(ev Event) {
+		panic("the panic should be recovered")
+	})
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []EventKind{
+		EventReloadStarted,
+		EventDepsWired, EventDepsWired,
+		EventOnLoadInvoked, EventOnLoadInvoked,
+		EventOnInitInvoked, EventOnInitInvoked,
+		EventReloadCommitted,
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected number of events: %d", len(events))
+	}
+	for i, ev := range events {
+		if ev.Kind != expected[i] {
+			t.Fatalf("unexpected event. i: %d, kind: %s", i, ev.Kind)
+		}
+		if ev.Err != nil {
+			t.Fatalf("unexpected error: %v", ev.Err)
+		}
+	}
+	if events[1].Plugin != "alpha" || events[2].Plugin != "beta" {
+		t.Fatal("the plugin name is not carried by the events")
+	}
+	if !log.StringExists("panic in the event handler") {
+		t.Fatal("the panic of the event handler should be logged")
+	}
+
+	unsubscribe()
+	events = nil
+	plugins["beta"].fOnLoad = func(data interface{}) error {
+		return errors.New("beta is broken")
+	}
+	swapper2 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins))
+	swapper2.Subscribe(func(ev Event) {
+		events = append(events, ev)
+	})
+	if _, err := swapper2.LoadPlugins(nil); err == nil {
+		t.Fatal("LoadPlugins should fail")
+	}
+	last := events[len(events)-1]
+	if last.Kind != EventReloadAborted || last.Err == nil {
+		t.Fatal("the last event should be EventReloadAborted")
+	}
+	onLoad := events[len(events)-2]
+	if onLoad.Kind != EventOnLoadInvoked || onLoad.Plugin != "beta" || onLoad.Err == nil {
+		t.Fatal("the failure of beta.OnLoad should be reported")
+	}
+}
@@ -0,0 +1,138 @@
+package hotswap
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// HealthStatus is the result of the latest health check of a plugin.
+type HealthStatus struct {
+	Err      error
+	When     time.Time
+	Duration time.Duration
+}
+
+// HealthStatus returns the result of the latest health check of the plugin. ok is false
+// if the plugin has never been checked.
+func (pl *Plugin) HealthStatus() (_ HealthStatus, ok bool) {
+	st, ok := pl.health.Load().(HealthStatus)
+	return st, ok
+}
+
+// CheckHealth calls Health of every plugin defining it, and returns an error describing
+// every unhealthy plugin. A quarantined plugin is always unhealthy. A Health call which does
+// not return before ctx is done keeps running in the background. Until it returns, the plugin
+// is not checked again and is reported unhealthy, so at most one Health call per plugin is
+// in flight.
+func (pm *PluginManager) CheckHealth(ctx context.Context) error {
+	var results InvokeResults
+	for _, p := range pm.ordered {
+		if p.fHealth == nil && !p.Quarantined() {
+			continue
+		}
+		start := time.Now()
+		err := pm.checkHealth(ctx, p)
+		p.health.Store(HealthStatus{Err: err, When: start, Duration: time.Since(start)})
+		pm.emit(EventHealthChecked, p.Name, start, err)
+		if err != nil {
+			results = append(results, InvokeResult{Plugin: p.Name, Err: err})
+		}
+	}
+	return results.Err()
+}
+
+func (pm *PluginManager) checkHealth(ctx context.Context, p *Plugin) error {
+	if p.Quarantined() {
+		return fmt.Errorf("<hotswap:%s> %w", p.Name, ErrPluginQuarantined)
+	}
+
+	if !p.checking.CompareAndSwap(false, true) {
+		return fmt.Errorf("<hotswap:%s> the previous Health call has not returned yet", p.Name)
+	}
+	ch := make(chan error, 1)
+	go func() {
+		defer p.checking.Store(false)
+		defer func() {
+			if r := recover(); r != nil {
+				ch <- fmt.Errorf("<hotswap:%s> panic: %+v\n%s", p.Name, r, debug.Stack())
+			}
+		}()
+		pm.Debugf("<hotswap> invoking %s.Health", p.Name)
+		ch <- p.fHealth()
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("<hotswap:%s> Health did not return in time. err: %w", p.Name, ctx.Err())
+	}
+}
+
+func (sw *PluginManagerSwapper) checkHealth(pm *PluginManager) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sw.opts.healthTimeout)
+	defer cancel()
+	return pm.CheckHealth(ctx)
+}
+
+// gateHealth rejects a new PluginManager with unhealthy plugins if the health gate is enabled.
+func (sw *PluginManagerSwapper) gateHealth(newManager *PluginManager) error {
+	if !sw.opts.healthGate {
+		return nil
+	}
+	if err := sw.checkHealth(newManager); err != nil {
+		return fmt.Errorf("the new plugins are unhealthy. err: %w", err)
+	}
+	return nil
+}
+
+// HealthMonitor checks the health of the current plugins periodically.
+type HealthMonitor struct {
+	sw       *PluginManagerSwapper
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// MonitorHealth calls CheckHealth on the current PluginManager at the health check interval.
+// The results are recorded on the plugins, see Plugin.HealthStatus.
+func (sw *PluginManagerSwapper) MonitorHealth() *HealthMonitor {
+	m := &HealthMonitor{
+		sw:   sw,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *HealthMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	<-m.done
+}
+
+func (m *HealthMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.sw.opts.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		pm := m.sw.Current()
+		if pm == nil {
+			continue
+		}
+		if err := m.sw.checkHealth(pm); err != nil {
+			m.sw.Warnf("<hotswap> health check failed. err: %v", err)
+		}
+	}
+}
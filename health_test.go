@@ -0,0 +1,111 @@
+package hotswap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+func TestPluginManager_CheckHealth(t *testing.T) {
+	plugins := newFakeStaticPlugins("alpha", "beta", "gamma")
+	plugins["alpha"].fHealth = func() error { return nil }
+	plugins["beta"].fHealth = func() error { return errors.New("broken") }
+	swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()), WithStaticPlugins(plugins))
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var checked []string
+	unsubscribe := swapper.Subscribe(func(ev Event) {
+		if ev.Kind == EventHealthChecked {
+			checked = append(checked, ev.Plugin)
+		}
+	})
+	defer unsubscribe()
+
+	mgr := swapper.Current()
+	if err := mgr.CheckHealth(context.Background()); err == nil {
+		t.Fatal("CheckHealth should fail")
+	}
+	if len(checked) != 2 {
+		t.Fatalf("unexpected health checks: %v", checked)
+	}
+	if st, ok := mgr.FindPlugin("alpha").HealthStatus(); !ok || st.Err != nil {
+		t.Fatalf("unexpected health status of alpha: %+v", st)
+	}
+	if st, ok := mgr.FindPlugin("beta").HealthStatus(); !ok || st.Err == nil {
+		t.Fatalf("unexpected health status of beta: %+v", st)
+	}
+	if _, ok := mgr.FindPlugin("gamma").HealthStatus(); ok {
+		t.Fatal("gamma has no Health function")
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	mgr.FindPlugin("beta").fHealth = func() error {
+		<-block
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := mgr.CheckHealth(ctx); err == nil {
+		t.Fatal("CheckHealth should fail")
+	}
+	if st, _ := mgr.FindPlugin("beta").HealthStatus(); !errors.Is(st.Err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected health status of beta: %+v", st)
+	}
+	if err := mgr.CheckHealth(context.Background()); err == nil || !strings.Contains(err.Error(), "has not returned yet") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWithHealthGate(t *testing.T) {
+	plugins := newFakeStaticPlugins("alpha")
+	var broken atomic.Bool
+	plugins["alpha"].fHealth = func() error {
+		if broken.Load() {
+			return errors.New("broken")
+		}
+		return nil
+	}
+	swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()), WithStaticPlugins(plugins),
+		WithHealthGate(), WithHealthCheckInterval(time.Millisecond*10))
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	mgr1 := swapper.Current()
+	broken.Store(true)
+	if _, err := swapper.LoadPlugins(nil); err == nil {
+		t.Fatal("LoadPlugins should fail")
+	}
+	if swapper.Current() != mgr1 {
+		t.Fatal("the unhealthy plugins should not be swapped in")
+	}
+
+	broken.Store(false)
+	checked := make(chan struct{}, 1)
+	unsubscribe := swapper.Subscribe(func(ev Event) {
+		if ev.Kind == EventHealthChecked && ev.Err == nil {
+			select {
+			case checked <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer unsubscribe()
+	start := time.Now()
+	monitor := swapper.MonitorHealth()
+	defer monitor.Stop()
+	select {
+	case <-checked:
+	case <-time.After(time.Second * 5):
+		t.Fatal("the monitor should keep checking the current plugins")
+	}
+	if st, ok := mgr1.FindPlugin("alpha").HealthStatus(); !ok || st.Err != nil || st.When.Before(start) {
+		t.Fatalf("unexpected health status: %+v", st)
+	}
+}
@@ -0,0 +1,245 @@
+package hutils
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+const (
+	pkgHashPrefix = "go:link.pkghashbytes."
+)
+
+// Mismatch describes a package or module whose version differs between a plugin and the host.
+type Mismatch struct {
+	Package       string
+	PluginVersion string
+	HostVersion   string
+	PluginHash    string `json:",omitempty"`
+	HostHash      string `json:",omitempty"`
+}
+
+func (m Mismatch) String() string {
+	var sb strings.Builder
+	sb.WriteString(m.Package)
+	sb.WriteString(" ")
+	sb.WriteString(m.PluginVersion)
+	if m.PluginHash != "" {
+		sb.WriteString(" [" + m.PluginHash + "]")
+	}
+	sb.WriteString(" (host: ")
+	sb.WriteString(m.HostVersion)
+	if m.HostHash != "" {
+		sb.WriteString(" [" + m.HostHash + "]")
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// CompatReport is the result of CheckCompatibility.
+type CompatReport struct {
+	PluginFile      string
+	HostFile        string
+	PluginGoVersion string
+	HostGoVersion   string
+	Manifest        *Manifest `json:",omitempty"`
+	// HashesCompared is false when the package hashes are unavailable, e.g. the files are
+	// not in the ELF format. Only the build info is compared in that case.
+	HashesCompared bool
+	Mismatches     []Mismatch `json:",omitempty"`
+}
+
+// OK reports whether no incompatibility was found.
+func (r *CompatReport) OK() bool {
+	return r.PluginGoVersion == r.HostGoVersion && len(r.Mismatches) == 0
+}
+
+// Err returns an error describing all incompatibilities, or nil.
+func (r *CompatReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	var a []string
+	if r.PluginGoVersion != r.HostGoVersion {
+		a = append(a, fmt.Sprintf("the plugin was built with %s, but the host was built with %s",
+			r.PluginGoVersion, r.HostGoVersion))
+	}
+	if len(r.Mismatches) > 0 {
+		b := make([]string, len(r.Mismatches))
+		for i, m := range r.Mismatches {
+			b[i] = m.String()
+		}
+		a = append(a, "mismatched packages: "+strings.Join(b, ", "))
+	}
+	return fmt.Errorf("%s is incompatible with %s. %s", r.PluginFile, r.HostFile, strings.Join(a, ". "))
+}
+
+// CheckCompatibility compares the build info, the manifest and the package hashes of
+// pluginFile against hostFile, and lists every package that would make plugin.Open fail
+// with "plugin was built with a different version of package".
+func CheckCompatibility(hostFile, pluginFile string) (*CompatReport, error) {
+	hostInfo, err := buildinfo.ReadFile(hostFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the build info of %s. err: %w", hostFile, err)
+	}
+	data, err := ioutil.ReadFile(pluginFile)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := FindManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the manifest of %s. err: %w", pluginFile, err)
+	}
+
+	r := &CompatReport{
+		PluginFile:    pluginFile,
+		HostFile:      hostFile,
+		HostGoVersion: hostInfo.GoVersion,
+		Manifest:      manifest,
+	}
+	hostMods := moduleVersions(hostInfo)
+	var pluginMods map[string]string
+	if pluginInfo, err := buildinfo.ReadFile(pluginFile); err == nil {
+		r.PluginGoVersion = pluginInfo.GoVersion
+		pluginMods = moduleVersions(pluginInfo)
+	} else if manifest != nil {
+		r.PluginGoVersion = manifest.GoVersion
+		pluginMods = manifest.Deps
+	} else {
+		return nil, fmt.Errorf("failed to read the build info of %s. err: %w", pluginFile, err)
+	}
+
+	seen := make(map[string]struct{})
+	hostHashes, err1 := packageHashes(hostFile)
+	pluginHashes, err2 := packageHashes(pluginFile)
+	if err1 == nil && err2 == nil {
+		r.HashesCompared = true
+		for pkg, ph := range pluginHashes {
+			hh, ok := hostHashes[pkg]
+			if !ok || hh == ph {
+				continue
+			}
+			mod := moduleOf(pkg, pluginMods)
+			seen[mod] = struct{}{}
+			r.Mismatches = append(r.Mismatches, Mismatch{
+				Package:       pkg,
+				PluginVersion: packageVersion(pkg, mod, pluginMods, r.PluginGoVersion),
+				HostVersion:   packageVersion(pkg, moduleOf(pkg, hostMods), hostMods, r.HostGoVersion),
+				PluginHash:    ph,
+				HostHash:      hh,
+			})
+		}
+	}
+
+	for path, v := range pluginMods {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		hv, ok := hostMods[path]
+		if !ok || hv == "" || v == "" || hv == v || hv == "(devel)" || v == "(devel)" {
+			continue
+		}
+		r.Mismatches = append(r.Mismatches, Mismatch{
+			Package:       path,
+			PluginVersion: v,
+			HostVersion:   hv,
+		})
+	}
+
+	sort.Slice(r.Mismatches, func(i, j int) bool {
+		return r.Mismatches[i].Package < r.Mismatches[j].Package
+	})
+	return r, nil
+}
+
+func moduleVersions(bi *debug.BuildInfo) map[string]string {
+	mods := make(map[string]string)
+	if bi.Main.Path != "" {
+		mods[bi.Main.Path] = bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Replace != nil {
+			mods[dep.Path] = dep.Replace.Version
+		} else {
+			mods[dep.Path] = dep.Version
+		}
+	}
+	return mods
+}
+
+func moduleOf(pkg string, mods map[string]string) string {
+	var best string
+	for path := range mods {
+		if (pkg == path || strings.HasPrefix(pkg, path+"/")) && len(path) > len(best) {
+			best = path
+		}
+	}
+	return best
+}
+
+func packageVersion(pkg, mod string, mods map[string]string, goVersion string) string {
+	if mod != "" {
+		if v := mods[mod]; v != "" {
+			return v
+		}
+		return "(devel)"
+	}
+	if i := strings.Index(pkg, "/"); i < 0 || !strings.Contains(pkg[:i], ".") {
+		return goVersion
+	}
+	return "(unknown)"
+}
+
+// packageHashes returns the fingerprints of the packages linked into an ELF file, which are
+// what the runtime compares when a plugin is opened.
+func packageHashes(file string) (map[string]string, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// The package hashes are exported as dynamic symbols, which survive stripping.
+	syms, err := f.DynamicSymbols()
+	if err != nil || len(syms) == 0 {
+		if syms, err = f.Symbols(); err != nil {
+			return nil, err
+		}
+	}
+
+	hashes := make(map[string]string)
+	for _, sym := range syms {
+		if !strings.HasPrefix(sym.Name, pkgHashPrefix) || sym.Size == 0 {
+			continue
+		}
+		buf, err := readSymbol(f, sym)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s. err: %w", sym.Name, err)
+		}
+		hashes[strings.TrimPrefix(sym.Name, pkgHashPrefix)] = hex.EncodeToString(buf)
+	}
+	if len(hashes) == 0 {
+		return nil, errors.New("no package hash is found")
+	}
+	return hashes, nil
+}
+
+func readSymbol(f *elf.File, sym elf.Symbol) ([]byte, error) {
+	if int(sym.Section) >= len(f.Sections) {
+		return nil, errors.New("bad section index")
+	}
+	sec := f.Sections[sym.Section]
+	if sec.Type == elf.SHT_NOBITS || sym.Value < sec.Addr || sym.Value+sym.Size > sec.Addr+sec.Size {
+		return nil, errors.New("the symbol is out of its section")
+	}
+	buf := make([]byte, sym.Size)
+	if _, err := sec.ReadAt(buf, int64(sym.Value-sec.Addr)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,54 @@
+package hutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	ManifestBegin = "<hotswap:manifest>"
+	ManifestEnd   = "</hotswap:manifest>"
+)
+
+type Manifest struct {
+	Plugin     string
+	Commit     string
+	CommitTime time.Time
+	BuildTime  time.Time
+	GoVersion  string
+	Deps       map[string]string `json:",omitempty"`
+	LiveFuncs  []string          `json:",omitempty"`
+	LiveTypes  []string          `json:",omitempty"`
+}
+
+// EncodeManifest returns the manifest wrapped by the markers, which is embedded into the
+// plugin as a string constant.
+func EncodeManifest(m *Manifest) (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return ManifestBegin + string(data) + ManifestEnd, nil
+}
+
+// FindManifest looks for the embedded manifest in the content of a plugin file. It returns
+// nil if the plugin has no manifest.
+func FindManifest(data []byte) (*Manifest, error) {
+	begin := bytes.Index(data, []byte(ManifestBegin+"{"))
+	if begin < 0 {
+		return nil, nil
+	}
+	data = data[begin+len(ManifestBegin):]
+	end := bytes.Index(data, []byte(ManifestEnd))
+	if end < 0 {
+		return nil, fmt.Errorf("the end of the manifest is missing")
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data[:end], &m); err != nil {
+		return nil, fmt.Errorf("malformed manifest. err: %w", err)
+	}
+	return &m, nil
+}
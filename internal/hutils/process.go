@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package hutils
+
+import (
+	"syscall"
+)
+
+// ProcessAlive reports whether a process with the pid exists.
+func ProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
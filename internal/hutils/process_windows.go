@@ -0,0 +1,6 @@
+package hutils
+
+// ProcessAlive always returns true on Windows, where Go plugins are not supported.
+func ProcessAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,76 @@
+package hutils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const (
+	SignatureExt = ".sig"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// SignatureFile returns the path of the detached signature of a plugin file.
+func SignatureFile(pluginFile string) string {
+	return pluginFile + SignatureExt
+}
+
+// signedMessage binds the plugin name and version to the SHA-256 of the plugin, so that a
+// signature cannot be reused for another plugin or another version.
+func signedMessage(name, version string, sum [sha256.Size]byte) []byte {
+	msg := []byte("hotswap-plugin\x00")
+	msg = append(msg, name...)
+	msg = append(msg, 0)
+	msg = append(msg, version...)
+	msg = append(msg, 0)
+	return append(msg, sum[:]...)
+}
+
+// SignPlugin signs the name, the version and the SHA-256 of the plugin data, and returns the
+// content of the signature file. version is empty for an unversioned plugin.
+func SignPlugin(key ed25519.PrivateKey, name, version string, data []byte) []byte {
+	sig := ed25519.Sign(key, signedMessage(name, version, sha256.Sum256(data)))
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// VerifyPlugin checks the content of a signature file against the name, the version and the
+// SHA-256 of a plugin. Any one of the keys is enough.
+func VerifyPlugin(keys []ed25519.PublicKey, name, version string, sum [sha256.Size]byte, sigFileData []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sigFileData)))
+	if err != nil {
+		return fmt.Errorf("malformed signature. err: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature. size: %d", len(sig))
+	}
+	msg := signedMessage(name, version, sum)
+	for _, key := range keys {
+		if ed25519.Verify(key, msg, sig) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// ParsePrivateKey accepts a base64-encoded ed25519 seed or private key.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("malformed private key. err: %w", err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	default:
+		return nil, fmt.Errorf("malformed private key. size: %d", len(b))
+	}
+}
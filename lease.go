@@ -0,0 +1,77 @@
+package hotswap
+
+import (
+	"sync"
+)
+
+type leases struct {
+	mu      sync.Mutex
+	n       int
+	retired bool
+	drained chan struct{}
+}
+
+func (l *leases) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.retired && l.n == 0 {
+		return false
+	}
+	l.n++
+	return true
+}
+
+func (l *leases) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.n--
+	if l.retired && l.n == 0 {
+		close(l.drained)
+	}
+}
+
+// retire returns a channel which is closed once all leases are released. No lease can be
+// acquired after the channel is closed.
+func (l *leases) retire() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.retired {
+		l.retired = true
+		l.drained = make(chan struct{})
+		if l.n == 0 {
+			close(l.drained)
+		}
+	}
+	return l.drained
+}
+
+func (l *leases) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.n
+}
+
+// Acquire returns the current PluginManager with a lease on it. Call release once you are
+// done with the PluginManager. Under WithLeaseDraining, a replaced PluginManager is freed as
+// soon as all its leases are released, but not before the minimum free delay, or after the
+// free delay, whichever comes first. Acquire returns nil if there is no PluginManager that
+// can be leased.
+func (sw *PluginManagerSwapper) Acquire() (_ *PluginManager, release func()) {
+	for {
+		pm := sw.Current()
+		if pm == nil {
+			return nil, func() {}
+		}
+		if pm.leases.acquire() {
+			var once sync.Once
+			return pm, func() {
+				once.Do(pm.leases.release)
+			}
+		}
+		// A retired PluginManager never becomes current again, so retry only if the current
+		// one has been replaced in the meantime.
+		if sw.Current() == pm {
+			return nil, func() {}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package hotswap
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/edwingeng/hotswap/internal/hutils"
+)
+
+// Manifest is embedded into a plugin by "hotswap build".
+type Manifest = hutils.Manifest
+
+func hostDependencies() map[string]string {
+	deps := make(map[string]string)
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return deps
+	}
+	for _, dep := range bi.Deps {
+		if dep.Replace != nil {
+			deps[dep.Path] = dep.Replace.Version
+		} else {
+			deps[dep.Path] = dep.Version
+		}
+	}
+	return deps
+}
+
+func checkManifest(m *Manifest, hostDeps map[string]string) error {
+	if m.GoVersion != runtime.Version() {
+		return fmt.Errorf("the plugin was built with %s, but the host was built with %s",
+			m.GoVersion, runtime.Version())
+	}
+
+	var a []string
+	for path, v := range m.Deps {
+		hv, ok := hostDeps[path]
+		if !ok || hv == "" || v == "" || hv == v {
+			continue
+		}
+		a = append(a, fmt.Sprintf("%s %s (host: %s)", path, v, hv))
+	}
+	if len(a) > 0 {
+		sort.Strings(a)
+		return fmt.Errorf("dependency version mismatch: %s", strings.Join(a, ", "))
+	}
+	return nil
+}
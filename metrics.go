@@ -0,0 +1,96 @@
+package hotswap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives the measurements of a PluginManagerSwapper. The metrics package provides
+// an implementation in the Prometheus text exposition format. All methods may be called
+// concurrently.
+type Metrics interface {
+	// ObserveReload is called at the end of every reload. phase is empty if the reload
+	// succeeded, or the step which failed otherwise, e.g. "PluginOpened" or "OnLoadInvoked".
+	ObserveReload(d time.Duration, phase string, err error)
+	// SetLiveGenerations is called with the number of generations which have not been freed yet.
+	SetLiveGenerations(n int64)
+	// SetPluginRefs is called with the reference count of a plugin whenever it changes.
+	SetPluginRefs(plugin string, refs int64)
+	// ObserveInvoke is called after every call of InvokeFunc or InvokeFuncContext. fn is the
+	// name passed by the caller, which may take any value.
+	ObserveInvoke(plugin, fn string, d time.Duration, err error, panicked bool)
+}
+
+type metricsState struct {
+	liveGenerations int64
+}
+
+func (sw *PluginManagerSwapper) recordMetrics(ev Event) {
+	m := sw.opts.metrics
+	switch ev.Kind {
+	case EventReloadCommitted:
+		m.ObserveReload(ev.Duration, "", nil)
+		m.SetLiveGenerations(atomic.AddInt64(&sw.metricsState.liveGenerations, 1))
+		sw.recordPluginRefs(nil)
+	case EventGenerationFreed:
+		m.SetLiveGenerations(atomic.AddInt64(&sw.metricsState.liveGenerations, -1))
+	}
+}
+
+// recordAbortedReload records a failed reload. failed is the step which failed, or 0 if the
+// reload failed outside the steps, e.g. in a ReloadCallback.
+func (sw *PluginManagerSwapper) recordAbortedReload(start time.Time, failed EventKind, err error) {
+	m := sw.opts.metrics
+	if m == nil {
+		return
+	}
+	phase := "Other"
+	if failed != 0 {
+		phase = failed.String()
+	}
+	m.ObserveReload(time.Since(start), phase, err)
+}
+
+// trackFailedStep makes the new PluginManager of a reload remember the last step which
+// failed. The returned function reports it.
+func trackFailedStep(pm *PluginManager) func() EventKind {
+	var mu sync.Mutex
+	var failed EventKind
+	emit := pm.emit
+	pm.emit = func(kind EventKind, plugin string, start time.Time, err error) {
+		if err != nil {
+			mu.Lock()
+			failed = kind
+			mu.Unlock()
+		}
+		emit(kind, plugin, start, err)
+	}
+	return func() EventKind {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed
+	}
+}
+
+// recordPluginRefs records the reference counts of the current plugins, and of the plugins
+// of a freed generation which are not current any more.
+func (sw *PluginManagerSwapper) recordPluginRefs(freed *PluginManager) {
+	m := sw.opts.metrics
+	if m == nil {
+		return
+	}
+	cur := sw.Current()
+	if freed != nil {
+		for _, p := range freed.pluginMap {
+			if cur == nil || cur.FindPlugin(p.Name) == nil {
+				m.SetPluginRefs(p.Name, p.Refs.Load())
+			}
+		}
+	}
+	if cur != nil {
+		for _, p := range cur.pluginMap {
+			m.SetPluginRefs(p.Name, p.Refs.Load())
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package metrics implements hotswap.Metrics in the Prometheus text exposition format,
+// without depending on the Prometheus client. Serve a Registry at /metrics, or call WriteTo
+// to export it in any other way.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edwingeng/hotswap"
+)
+
+var _ hotswap.Metrics = (*Registry)(nil)
+
+// OtherFunc is the func label of the invocations of the functions not passed to WithInvokeFuncs.
+const OtherFunc = "other"
+
+var (
+	// DefaultReloadBuckets are the histogram buckets of reload durations, in seconds.
+	DefaultReloadBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	// DefaultInvokeBuckets are the histogram buckets of invocation latencies, in seconds.
+	DefaultInvokeBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type invokeKey struct {
+	plugin string
+	fn     string
+}
+
+type invokeStats struct {
+	latency *histogram
+	errors  uint64
+	panics  uint64
+}
+
+// Registry collects the measurements of a PluginManagerSwapper. Pass it to
+// hotswap.WithMetrics.
+type Registry struct {
+	mu sync.Mutex
+
+	namespace     string
+	invokeBuckets []float64
+	invokeFuncs   map[string]bool
+
+	reloads         uint64
+	reloadFailures  map[string]uint64
+	reloadLatency   *histogram
+	liveGenerations int64
+	pluginRefs      map[string]int64
+	invokes         map[invokeKey]*invokeStats
+}
+
+type Option func(r *Registry)
+
+// WithNamespace sets the prefix of the metric names. The default value is "hotswap".
+func WithNamespace(ns string) Option {
+	return func(r *Registry) {
+		r.namespace = ns
+	}
+}
+
+// WithReloadBuckets sets the histogram buckets of reload durations, in seconds.
+func WithReloadBuckets(buckets []float64) Option {
+	return func(r *Registry) {
+		r.reloadLatency = newHistogram(sortedBuckets(buckets))
+	}
+}
+
+// WithInvokeBuckets sets the histogram buckets of invocation latencies, in seconds.
+func WithInvokeBuckets(buckets []float64) Option {
+	return func(r *Registry) {
+		r.invokeBuckets = sortedBuckets(buckets)
+	}
+}
+
+// WithInvokeFuncs sets the function names used as the func label of the invocation metrics.
+// The invocations of the other functions are recorded under OtherFunc, so that the label
+// cannot grow without bound. By default, every invocation is recorded under OtherFunc.
+func WithInvokeFuncs(names ...string) Option {
+	return func(r *Registry) {
+		r.invokeFuncs = make(map[string]bool)
+		for _, name := range names {
+			r.invokeFuncs[name] = true
+		}
+	}
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	a := append([]float64(nil), buckets...)
+	sort.Float64s(a)
+	return a
+}
+
+func NewRegistry(opts ...Option) *Registry {
+	r := &Registry{
+		namespace:      "hotswap",
+		invokeBuckets:  DefaultInvokeBuckets,
+		reloadFailures: make(map[string]uint64),
+		reloadLatency:  newHistogram(DefaultReloadBuckets),
+		pluginRefs:     make(map[string]int64),
+		invokes:        make(map[invokeKey]*invokeStats),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *Registry) ObserveReload(d time.Duration, phase string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloads++
+	if phase != "" {
+		r.reloadFailures[phase]++
+	}
+	r.reloadLatency.observe(d.Seconds())
+}
+
+func (r *Registry) SetLiveGenerations(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveGenerations = n
+}
+
+func (r *Registry) SetPluginRefs(plugin string, refs int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pluginRefs[plugin] = refs
+}
+
+func (r *Registry) ObserveInvoke(plugin, fn string, d time.Duration, err error, panicked bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.invokeFuncs[fn] {
+		fn = OtherFunc
+	}
+	k := invokeKey{plugin: plugin, fn: fn}
+	st := r.invokes[k]
+	if st == nil {
+		st = &invokeStats{latency: newHistogram(r.invokeBuckets)}
+		r.invokes[k] = st
+	}
+	st.latency.observe(d.Seconds())
+	if err != nil {
+		st.errors++
+	}
+	if panicked {
+		st.panics++
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	ns := r.namespace
+	header := func(name, typ, help string) {
+		fmt.Fprintf(cw, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", ns, name, help, ns, name, typ)
+	}
+
+	header("reloads_total", "counter", "The number of reloads, failed ones included.")
+	fmt.Fprintf(cw, "%s_reloads_total %d\n", ns, r.reloads)
+
+	header("reload_failures_total", "counter", "The number of failed reloads by the failing phase.")
+	for _, phase := range sortedKeys(r.reloadFailures) {
+		fmt.Fprintf(cw, "%s_reload_failures_total{phase=\"%s\"} %d\n", ns, escape(phase), r.reloadFailures[phase])
+	}
+
+	header("reload_duration_seconds", "histogram", "The duration of reloads.")
+	writeHistogram(cw, ns+"_reload_duration_seconds", "", r.reloadLatency)
+
+	header("live_generations", "gauge", "The number of generations which have not been freed yet.")
+	fmt.Fprintf(cw, "%s_live_generations %d\n", ns, r.liveGenerations)
+
+	header("plugin_refs", "gauge", "The reference count of every plugin.")
+	for _, name := range sortedKeys(r.pluginRefs) {
+		fmt.Fprintf(cw, "%s_plugin_refs{plugin=\"%s\"} %d\n", ns, escape(name), r.pluginRefs[name])
+	}
+
+	keys := make([]invokeKey, 0, len(r.invokes))
+	for k := range r.invokes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].plugin != keys[j].plugin {
+			return keys[i].plugin < keys[j].plugin
+		}
+		return keys[i].fn < keys[j].fn
+	})
+	labels := func(k invokeKey) string {
+		return fmt.Sprintf("plugin=\"%s\",func=\"%s\"", escape(k.plugin), escape(k.fn))
+	}
+	header("invoke_duration_seconds", "histogram", "The latency of InvokeFunc calls.")
+	for _, k := range keys {
+		writeHistogram(cw, ns+"_invoke_duration_seconds", labels(k), r.invokes[k].latency)
+	}
+	header("invoke_errors_total", "counter", "The number of InvokeFunc calls returning an error.")
+	for _, k := range keys {
+		fmt.Fprintf(cw, "%s_invoke_errors_total{%s} %d\n", ns, labels(k), r.invokes[k].errors)
+	}
+	header("invoke_panics_total", "counter", "The number of InvokeFunc calls which panicked.")
+	for _, k := range keys {
+		fmt.Fprintf(cw, "%s_invoke_panics_total{%s} %d\n", ns, labels(k), r.invokes[k].panics)
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	a := make([]string, 0, len(m))
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return a
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
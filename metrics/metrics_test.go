@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/edwingeng/hotswap"
+	"github.com/edwingeng/slog"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry(WithInvokeFuncs("hello", "fail", "panic"))
+	invokeFunc := func(name string, params ...interface{}) (interface{}, error) {
+		switch name {
+		case "fail":
+			return nil, errors.New("failed")
+		case "panic":
+			panic("boom")
+		}
+		return nil, nil
+	}
+	swapper := hotswap.NewPluginManagerSwapper("",
+		hotswap.WithLogger(slog.NewScavenger()),
+		hotswap.WithStaticPlugins(map[string]*hotswap.StaticPlugin{
+			"alpha": hotswap.NewStaticPlugin("alpha", hotswap.WithInvokeFunc(invokeFunc)),
+		}),
+		hotswap.WithMetrics(reg),
+	)
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	pm := swapper.Current()
+	pm.InvokeEach("hello")
+	pm.InvokeEach("fail")
+	pm.InvokeEach("panic")
+	pm.InvokeEach("unknown")
+	if _, err := pm.FindPlugin("alpha").InvokeFunc("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	expected := []string{
+		"# TYPE hotswap_reloads_total counter\nhotswap_reloads_total 1\n",
+		"hotswap_reload_duration_seconds_count 1\n",
+		"hotswap_live_generations 1\n",
+		`hotswap_plugin_refs{plugin="alpha"} 1` + "\n",
+		`hotswap_invoke_duration_seconds_count{plugin="alpha",func="hello"} 2` + "\n",
+		`hotswap_invoke_duration_seconds_bucket{plugin="alpha",func="fail",le="+Inf"} 1` + "\n",
+		`hotswap_invoke_errors_total{plugin="alpha",func="fail"} 1` + "\n",
+		`hotswap_invoke_panics_total{plugin="alpha",func="panic"} 1` + "\n",
+		`hotswap_invoke_panics_total{plugin="alpha",func="hello"} 0` + "\n",
+		`hotswap_invoke_duration_seconds_count{plugin="alpha",func="other"} 1` + "\n",
+	}
+	for _, str := range expected {
+		if !strings.Contains(out, str) {
+			t.Fatalf("cannot find %q in:\n%s", str, out)
+		}
+	}
+
+	if strings.Contains(out, `func="unknown"`) {
+		t.Fatalf("unknown functions should be recorded under %s:\n%s", OtherFunc, out)
+	}
+
+	reg.ObserveReload(0, "PluginOpened", errors.New("failed"))
+	buf.Reset()
+	_, _ = reg.WriteTo(&buf)
+	if !strings.Contains(buf.String(), `hotswap_reload_failures_total{phase="PluginOpened"} 1`) {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,114 @@
+package hotswap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeMetrics struct {
+	mu              sync.Mutex
+	phases          []string
+	liveGenerations int64
+	refs            map[string]int64
+}
+
+func (m *fakeMetrics) ObserveReload(d time.Duration, phase string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.phases = append(m.phases, phase)
+}
+
+func (m *fakeMetrics) SetLiveGenerations(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.liveGenerations = n
+}
+
+func (m *fakeMetrics) SetPluginRefs(plugin string, refs int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refs[plugin] = refs
+}
+
+func (m *fakeMetrics) ObserveInvoke(plugin, fn string, d time.Duration, err error, panicked bool) {}
+
+func TestWithMetrics(t *testing.T) {
+	oldMinFreeDelay := minFreeDelay
+	minFreeDelay = time.Millisecond * 10
+	defer func() {
+		minFreeDelay = oldMinFreeDelay
+	}()
+
+	plugins := newFakeStaticPlugins("alpha")
+	plugins["alpha"].fOnLoadContext = nil
+	plugins["alpha"].fOnLoad = func(data interface{}) error {
+		if data != nil {
+			return errors.New("broken")
+		}
+		return nil
+	}
+	m := &fakeMetrics{refs: make(map[string]int64)}
+	swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()), WithStaticPlugins(plugins),
+		WithMetrics(m), WithFreeDelay(0))
+	if _, err := swapper.LoadPlugins(1); err == nil {
+		t.Fatal("LoadPlugins should fail")
+	}
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	mgr1 := swapper.Current()
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	swapper.scheduleFree(mgr1)
+	time.Sleep(time.Millisecond * 100)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.phases) != 3 || m.phases[0] != "OnLoadInvoked" || m.phases[1] != "" {
+		t.Fatalf("unexpected phases: %q", m.phases)
+	}
+	if m.liveGenerations != 1 || m.refs["alpha"] != 1 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestWithMetrics_healthMonitor(t *testing.T) {
+	plugins := newFakeStaticPlugins("alpha")
+	plugins["alpha"].fHealth = func() error {
+		return errors.New("unhealthy")
+	}
+	var broken int32
+	plugins["alpha"].fOnLoadContext = nil
+	plugins["alpha"].fOnLoad = func(data interface{}) error {
+		if atomic.LoadInt32(&broken) != 0 {
+			return errors.New("broken")
+		}
+		return nil
+	}
+	m := &fakeMetrics{refs: make(map[string]int64)}
+	swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()), WithStaticPlugins(plugins),
+		WithMetrics(m), WithFreeDelay(0), WithHealthCheckInterval(time.Millisecond))
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	monitor := swapper.MonitorHealth()
+	defer monitor.Stop()
+
+	for i := 0; i < 20; i++ {
+		atomic.StoreInt32(&broken, int32(i%2))
+		_, _ = swapper.LoadPlugins(nil)
+		time.Sleep(time.Millisecond)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, phase := range m.phases {
+		if phase != "" && phase != "OnLoadInvoked" {
+			t.Fatalf("unexpected phases: %q", m.phases)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package hotswap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"github.com/edwingeng/slog"
+)
+
+// PanicPolicy decides what happens when InvokeFunc or InvokeFuncContext of a plugin panics.
+type PanicPolicy int
+
+const (
+	// PanicPropagate lets the panic reach the caller. InvokeEach and its variants still
+	// recover it. This is the default policy.
+	PanicPropagate PanicPolicy = iota
+	// PanicLog recovers the panic, logs it and returns nil results.
+	PanicLog
+	// PanicReturnError recovers the panic and returns it as a *PluginPanicError.
+	PanicReturnError
+	// PanicQuarantine behaves like PanicReturnError, and quarantines a plugin after it
+	// panics a certain number of times. See WithPanicQuarantine.
+	PanicQuarantine
+)
+
+// ErrPluginQuarantined is returned by the calls to a quarantined plugin.
+var ErrPluginQuarantined = errors.New("the plugin is quarantined")
+
+// PluginPanicError is returned by InvokeFunc and InvokeFuncContext when the plugin panics
+// under PanicReturnError or PanicQuarantine.
+type PluginPanicError struct {
+	Plugin string
+	Func   string
+	Value  interface{}
+	Stack  []byte
+}
+
+func (e *PluginPanicError) Error() string {
+	return fmt.Sprintf("<hotswap:%s> panic in %s: %+v\n%s", e.Plugin, e.Func, e.Value, e.Stack)
+}
+
+// Panics returns the number of panics of the plugin functions caught by hotswap, the
+// functions returned by Method included. Under PanicPropagate, the panics reaching the callers
+// of InvokeFunc directly are counted only if WithMetrics or WithRollbackWindow is used.
+func (pl *Plugin) Panics() int64 {
+	return pl.panics.Load()
+}
+
+// countPanic counts a panic recovered by InvokeEach and its variants, unless the guard of the
+// plugin has counted it before propagating it.
+func (pl *Plugin) countPanic() {
+	if pl.guard == nil || pl.guard.policy != PanicPropagate {
+		pl.panics.Inc()
+	}
+}
+
+// Quarantined reports whether the plugin is quarantined.
+func (pl *Plugin) Quarantined() bool {
+	return pl.quarantined.Load()
+}
+
+// callGuard applies the metrics and the panic policy to the calls into a plugin.
+type callGuard struct {
+	log       slog.Logger
+	metrics   Metrics
+	policy    PanicPolicy
+	threshold int64
+}
+
+// call calls f, which returns the error returned by the plugin function fn.
+func (g *callGuard) call(p *Plugin, fn string, f func() error) (err error) {
+	if p.quarantined.Load() {
+		return fmt.Errorf("<hotswap:%s> %w", p.Name, ErrPluginQuarantined)
+	}
+
+	start := time.Now()
+	defer func() {
+		r := recover()
+		if g.metrics != nil {
+			if r != nil {
+				g.metrics.ObserveInvoke(p.Name, fn, time.Since(start), nil, true)
+			} else {
+				g.metrics.ObserveInvoke(p.Name, fn, time.Since(start), err, false)
+			}
+		}
+		if r == nil {
+			return
+		}
+		n := p.panics.Inc()
+		if g.policy == PanicPropagate {
+			panic(r)
+		}
+
+		perr := &PluginPanicError{Plugin: p.Name, Func: fn, Value: r, Stack: debug.Stack()}
+		g.log.Error(perr)
+		if g.policy == PanicQuarantine && n >= g.threshold && p.quarantined.CompareAndSwap(false, true) {
+			g.log.Errorf("<hotswap:%s> quarantined after %d panic(s)", p.Name, n)
+		}
+		if g.policy != PanicLog {
+			err = perr
+		}
+	}()
+	return f()
+}
+
+// guardCalls wraps InvokeFunc and InvokeFuncContext of p with the metrics and the panic policy,
+// so that direct calls are covered as well as InvokeEach and its variants. The functions
+// returned by Method are wrapped as well. The calls are also wrapped to count the panics
+// under a rollback window.
+func (pm *PluginManager) guardCalls(p *Plugin) {
+	if pm.metrics == nil && pm.panicPolicy == PanicPropagate && !pm.watchPanics {
+		return
+	}
+	g := &callGuard{
+		log:       pm.Logger,
+		metrics:   pm.metrics,
+		policy:    pm.panicPolicy,
+		threshold: int64(pm.quarantineAfter),
+	}
+	if g.threshold <= 0 {
+		g.threshold = 1
+	}
+	p.guard = g
+
+	if f := p.InvokeFunc; f != nil {
+		p.InvokeFunc = func(name string, params ...interface{}) (ret interface{}, err error) {
+			err = g.call(p, name, func() (err error) {
+				ret, err = f(name, params...)
+				return err
+			})
+			return ret, err
+		}
+	}
+	if f := p.InvokeFuncContext; f != nil {
+		p.InvokeFuncContext = func(ctx context.Context, name string, params ...interface{}) (ret interface{}, err error) {
+			err = g.call(p, name, func() (err error) {
+				ret, err = f(ctx, name, params...)
+				return err
+			})
+			return ret, err
+		}
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// guardMethod wraps a method returned by Method with the guard of the plugin. If the method
+// does not return an error as its last result, a recovered panic or a quarantined plugin
+// results in zero values only.
+func guardMethod[F any](pl *Plugin, name string, fn F) F {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if pl.guard == nil || t.Kind() != reflect.Func {
+		return fn
+	}
+	errIdx := -1
+	if n := t.NumOut(); n > 0 && t.Out(n-1) == errorType {
+		errIdx = n - 1
+	}
+
+	wrapped := reflect.MakeFunc(t, func(args []reflect.Value) (out []reflect.Value) {
+		err := pl.guard.call(pl, name, func() error {
+			if t.IsVariadic() {
+				out = v.CallSlice(args)
+			} else {
+				out = v.Call(args)
+			}
+			if errIdx >= 0 && !out[errIdx].IsNil() {
+				return out[errIdx].Interface().(error)
+			}
+			return nil
+		})
+		if out == nil {
+			out = make([]reflect.Value, t.NumOut())
+			for i := range out {
+				out[i] = reflect.Zero(t.Out(i))
+			}
+		}
+		if errIdx >= 0 && err != nil {
+			out[errIdx] = reflect.ValueOf(&err).Elem()
+		}
+		return out
+	})
+	return wrapped.Interface().(F)
+}
@@ -0,0 +1,141 @@
+package hotswap
+
+import (
+	"errors"
+	"testing"
+)
+
+func newPanickingStaticPlugins() map[string]*StaticPlugin {
+	plugins := newFakeStaticPlugins("alpha", "beta")
+	plugins["alpha"].InvokeFunc = func(name string, params ...interface{}) (interface{}, error) {
+		if name == "boom" {
+			panic("boom")
+		}
+		return name, nil
+	}
+	return plugins
+}
+
+func TestWithPanicPolicy(t *testing.T) {
+	for _, policy := range []PanicPolicy{PanicLog, PanicReturnError} {
+		swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()),
+			WithStaticPlugins(newPanickingStaticPlugins()), WithPanicPolicy(policy))
+		if _, err := swapper.LoadPlugins(nil); err != nil {
+			t.Fatal(err)
+		}
+		alpha := swapper.Current().FindPlugin("alpha")
+		ret, err := alpha.InvokeFunc("boom")
+		if ret != nil {
+			t.Fatalf("unexpected result: %v", ret)
+		}
+		var perr *PluginPanicError
+		switch policy {
+		case PanicLog:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case PanicReturnError:
+			if !errors.As(err, &perr) || perr.Plugin != "alpha" || perr.Func != "boom" || len(perr.Stack) == 0 {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if results := swapper.Current().InvokeEachWithResults("boom"); (results.Err() != nil) != (policy == PanicReturnError) {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		if alpha.Panics() != 2 || alpha.Quarantined() {
+			t.Fatal("unexpected panic state")
+		}
+	}
+
+	swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()), WithStaticPlugins(newPanickingStaticPlugins()))
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("the panic should propagate by default")
+			}
+		}()
+		_, _ = swapper.Current().FindPlugin("alpha").InvokeFunc("boom")
+	}()
+}
+
+func TestWithPanicPolicy_method(t *testing.T) {
+	plugins := newPanickingStaticPlugins()
+	plugins["alpha"].hotswapMethods = func() map[string]interface{} {
+		return map[string]interface{}{
+			"Double": func(n int) (int, error) {
+				if n < 0 {
+					panic("negative")
+				}
+				return n * 2, nil
+			},
+			"Touch": func() {
+				panic("touch")
+			},
+		}
+	}
+	swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()),
+		WithStaticPlugins(plugins), WithPanicQuarantine(3))
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	alpha := swapper.Current().FindPlugin("alpha")
+	double, err := Method[func(int) (int, error)](alpha, "Double")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := double(2); err != nil || n != 4 {
+		t.Fatalf("unexpected result: %d, %v", n, err)
+	}
+	var perr *PluginPanicError
+	if n, err := double(-1); n != 0 || !errors.As(err, &perr) || perr.Func != "Double" {
+		t.Fatalf("unexpected result: %d, %v", n, err)
+	}
+	touch, err := Method[func()](alpha, "Touch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	touch()
+	touch()
+	if alpha.Panics() != 3 || !alpha.Quarantined() {
+		t.Fatal("alpha should be quarantined")
+	}
+	if _, err := double(2); !errors.Is(err, ErrPluginQuarantined) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWithPanicQuarantine(t *testing.T) {
+	swapper := NewPluginManagerSwapper("", WithLogger(newScavenger()),
+		WithStaticPlugins(newPanickingStaticPlugins()), WithPanicQuarantine(2))
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	mgr := swapper.Current()
+	alpha := mgr.FindPlugin("alpha")
+	mgr.InvokeEach("boom")
+	if alpha.Quarantined() {
+		t.Fatal("alpha should not be quarantined yet")
+	}
+	if _, err := alpha.InvokeFunc("boom"); err == nil {
+		t.Fatal("InvokeFunc should fail")
+	}
+	if !alpha.Quarantined() {
+		t.Fatal("alpha should be quarantined")
+	}
+	if _, err := alpha.InvokeFunc("hello"); !errors.Is(err, ErrPluginQuarantined) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, err := mgr.FindPlugin("beta").InvokeFunc("hello"); err != nil || v != "hello" {
+		t.Fatal("beta should not be affected")
+	}
+
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	if swapper.Current().FindPlugin("alpha").Quarantined() {
+		t.Fatal("a reloaded plugin should not be quarantined")
+	}
+}
@@ -1,7 +1,9 @@
 package hotswap
 
 import (
+	"context"
 	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"plugin"
@@ -10,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/edwingeng/hotswap/rpc"
 	"github.com/edwingeng/hotswap/vault"
 	"go.uber.org/atomic"
 )
@@ -19,41 +22,67 @@ var (
 )
 
 type PluginFuncs struct {
-	fOnLoad func(data interface{}) error
-	fOnInit func(sharedVault *vault.Vault) error
-	fOnFree func()
-
-	fExport     func() interface{}
-	fImport     func() interface{}
-	InvokeFunc  func(name string, params ...interface{}) (interface{}, error)
-	fReloadable func() bool
+	fOnLoad     func(data interface{}) error
+	fOnInit     func(sharedVault *vault.Vault) error
+	fOnFree     func()
+	fOnMigrate  func(old interface{}) (interface{}, error)
+	fOnSnapshot func() (interface{}, error)
+
+	fOnLoadContext func(ctx context.Context, data interface{}) error
+	fOnInitContext func(ctx context.Context, sharedVault *vault.Vault) error
+
+	fExport           func() interface{}
+	fHealth           func() error
+	fImport           func() interface{}
+	InvokeFunc        func(name string, params ...interface{}) (interface{}, error)
+	InvokeFuncContext func(ctx context.Context, name string, params ...interface{}) (interface{}, error)
+	fReloadable       func() bool
 
 	hotswapLiveFuncs func() map[string]interface{}
 	hotswapLiveTypes func() map[string]func() interface{}
+	hotswapMethods   func() map[string]interface{}
 }
 
 type Plugin struct {
-	Name      string
-	File      string
-	FileSha1  [sha1.Size]byte
-	When      time.Time
-	Note      string
-	unchanged bool
+	Name       string
+	File       string
+	Version    string
+	FileSha1   [sha1.Size]byte
+	FileSha256 [sha256.Size]byte
+	When       time.Time
+	Note       string
+	Manifest   *Manifest
+	unchanged  bool
 
 	P           *plugin.Plugin `json:"-"`
 	PluginFuncs `json:"-"`
 	Deps        []string
 	Refs        *atomic.Int64 `json:"-"`
+	actualFile  string
+	borrowed    bool
 	exported    interface{}
 	reloadable  bool
-
-	freeOnce *sync.Once
+	timings     PluginTimings
+	generation  int64
+	migrated    interface{}
+
+	freeOnce    *sync.Once
+	hookDone    chan struct{}
+	panics      *atomic.Int64
+	quarantined *atomic.Bool
+	health      *atomic.Value
+	checking    *atomic.Bool
+	guard       *callGuard
 }
 
 func newPlugin() *Plugin {
 	return &Plugin{
-		Refs:     atomic.NewInt64(1),
-		freeOnce: &sync.Once{},
+		Refs:        atomic.NewInt64(1),
+		freeOnce:    &sync.Once{},
+		panics:      atomic.NewInt64(0),
+		quarantined: atomic.NewBool(false),
+		health:      &atomic.Value{},
+		checking:    atomic.NewBool(false),
 	}
 }
 
@@ -105,7 +134,9 @@ func (pl *Plugin) Lookup(symName string, out interface{}) error {
 }
 
 func (pl *Plugin) invokeExport() (_ interface{}, err error) {
+	start := time.Now()
 	defer func() {
+		pl.timings.Export = time.Since(start)
 		if r := recover(); r != nil {
 			err = fmt.Errorf("<hotswap:%s> panic: %+v\n%s", pl.Name, r, debug.Stack())
 		}
@@ -122,6 +153,32 @@ func (pl *Plugin) invokeImport() (_ interface{}, err error) {
 	return pl.fImport(), nil
 }
 
+func (pl *Plugin) invokeOnMigrate(old interface{}) (_ interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("<hotswap:%s> panic: %+v\n%s", pl.Name, r, debug.Stack())
+		}
+	}()
+	return pl.fOnMigrate(old)
+}
+
+func (pl *Plugin) invokeOnSnapshot() (_ interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("<hotswap:%s> panic: %+v\n%s", pl.Name, r, debug.Stack())
+		}
+	}()
+	return pl.fOnSnapshot()
+}
+
+// InvokeContext calls InvokeFuncContext if the plugin defines it, or InvokeFunc otherwise.
+func (pl *Plugin) InvokeContext(ctx context.Context, name string, params ...interface{}) (interface{}, error) {
+	if pl.InvokeFuncContext != nil {
+		return pl.InvokeFuncContext(ctx, name, params...)
+	}
+	return pl.InvokeFunc(name, params...)
+}
+
 func (pl *Plugin) invokeReloadable() (_ bool, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -130,3 +187,25 @@ func (pl *Plugin) invokeReloadable() (_ bool, err error) {
 	}()
 	return pl.fReloadable(), nil
 }
+
+// ErrUnknownFunc is returned when a plugin has no method with the requested name.
+var ErrUnknownFunc = rpc.ErrUnknownFunc
+
+// Method returns the method of the type tagged with //hotswap:rpc in the plugin as an F,
+// e.g. func(string) (string, error). Like InvokeFunc, the returned function follows the panic
+// policy and reports to the Metrics.
+func Method[F any](pl *Plugin, name string) (F, error) {
+	var zero F
+	if pl.hotswapMethods == nil {
+		return zero, fmt.Errorf("%w: %s.%s", ErrUnknownFunc, pl.Name, name)
+	}
+	m, ok := pl.hotswapMethods()[name]
+	if !ok {
+		return zero, fmt.Errorf("%w: %s.%s", ErrUnknownFunc, pl.Name, name)
+	}
+	fn, ok := m.(F)
+	if !ok {
+		return zero, fmt.Errorf("unexpected signature of %s.%s. expected: %T, actual: %T", pl.Name, name, zero, m)
+	}
+	return guardMethod(pl, name, fn), nil
+}
@@ -1,9 +1,13 @@
 package hotswap
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -12,6 +16,7 @@ import (
 	"runtime/debug"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -30,7 +35,30 @@ type PluginManager struct {
 	when      time.Time
 
 	vault.Vault
-
+	report *ReloadReport
+	leases leases
+
+	dryRun       bool
+	preopened    map[[sha1.Size]byte]*Plugin
+	liveFuncSigs map[string]interface{}
+	namespaced   bool
+	hookTimeout  time.Duration
+	sigKeys      []ed25519.PublicKey
+
+	checkManifest bool
+	hostDeps      map[string]string
+
+	source      PluginSource
+	metrics     Metrics
+	tempDir     string
+	tempCleanup bool
+	privateDir  string
+
+	panicPolicy     PanicPolicy
+	quarantineAfter int
+	watchPanics     bool
+
+	emit         func(kind EventKind, plugin string, start time.Time, err error)
 	cbOpen       func(p *Plugin, data interface{})
 	panicTrigger func(data interface{})
 }
@@ -53,6 +81,7 @@ func newPluginManager(log slog.Logger, newExt func() interface{}) *PluginManager
 		dirName:      dirName,
 		pluginMap:    make(map[string]*Plugin),
 		Vault:        v,
+		emit:         func(EventKind, string, time.Time, error) {},
 		cbOpen:       func(*Plugin, interface{}) {},
 		panicTrigger: func(interface{}) {},
 	}
@@ -62,6 +91,7 @@ func (pm *PluginManager) addUnchanged(oldP *Plugin, note string) {
 	newP := *oldP
 	newP.unchanged = true
 	newP.Note = note
+	newP.timings = PluginTimings{}
 	newP.Refs.Inc()
 	pm.pluginMap[name2key(oldP.Name)] = &newP
 }
@@ -84,7 +114,15 @@ func (pm *PluginManager) outputStats1(infoMap fileInfoMap) {
 	pm.Infof("<hotswap> not reloadable: [%s], unchanged: [%s], to be loaded: [%s]", str1, str2, str3)
 }
 
-func (pm *PluginManager) loadPlugins(files []string, oldManager *PluginManager, data interface{}) (errRet error) {
+func (pm *PluginManager) loadPlugins(files []string, oldManager *PluginManager, data interface{}) error {
+	entries := make([]PluginEntry, len(files))
+	for i, file := range files {
+		entries[i] = PluginEntry{Name: pluginName(file), Path: file}
+	}
+	return pm.loadPluginsKeeping(entries, nil, oldManager, data)
+}
+
+func (pm *PluginManager) loadPluginsKeeping(entries []PluginEntry, keep []string, oldManager *PluginManager, data interface{}) (errRet error) {
 	var curFileInfo *fileInfo
 	defer func() {
 		if r := recover(); r != nil {
@@ -93,8 +131,11 @@ func (pm *PluginManager) loadPlugins(files []string, oldManager *PluginManager,
 				pName = "." + curFileInfo.name
 			}
 			errRet = fmt.Errorf("<hotswap%s> panic: %+v\n%s", pName, r, debug.Stack())
-			pm.invokeEveryOnFree()
-		} else if errRet != nil {
+		}
+		switch {
+		case pm.dryRun:
+			pm.releaseUnchanged()
+		case errRet != nil:
 			pm.invokeEveryOnFree()
 		}
 	}()
@@ -104,16 +145,20 @@ func (pm *PluginManager) loadPlugins(files []string, oldManager *PluginManager,
 	}
 
 	counters := make(map[string]int)
-	for _, file := range files {
-		counters[name2key(pluginName(file))]++
+	for _, e := range entries {
+		counters[name2key(e.Name)]++
 	}
 	for k, v := range counters {
 		if v > 1 {
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Path)
+			}
 			return fmt.Errorf("duplicate name detected: %s. files: %s", k, strings.Join(files, ", "))
 		}
 	}
 
-	infoMap, err := buildFileInfoMap(files)
+	infoMap, err := pm.buildFileInfoMap(entries)
 	if err != nil {
 		return err
 	}
@@ -126,8 +171,13 @@ func (pm *PluginManager) loadPlugins(files []string, oldManager *PluginManager,
 	for k := range unchanged {
 		pm.addUnchanged(oldManager.pluginMap[k], "unchanged")
 	}
-	if infoMap.Len()+len(pm.pluginMap) != len(files) {
-		return errors.New("infoMap.Len()+len(pm.pluginMap) != len(files)")
+	for _, k := range keep {
+		if _, ok := pm.pluginMap[k]; !ok {
+			pm.addUnchanged(oldManager.pluginMap[k], "unchanged")
+		}
+	}
+	if infoMap.Len()+len(pm.pluginMap) != len(entries)+len(keep) {
+		return errors.New("infoMap.Len()+len(pm.pluginMap) != len(entries)+len(keep)")
 	}
 
 	pm.outputStats1(infoMap)
@@ -145,6 +195,12 @@ func (pm *PluginManager) loadPlugins(files []string, oldManager *PluginManager,
 	if err := pm.initDeps(); err != nil {
 		return err
 	}
+	if pm.dryRun {
+		return pm.setupVault()
+	}
+	if err := pm.invokeEveryOnMigrate(oldManager); err != nil {
+		return err
+	}
 	if err := pm.invokeEveryOnLoad(data); err != nil {
 		return err
 	}
@@ -159,8 +215,20 @@ func (pm *PluginManager) loadPlugins(files []string, oldManager *PluginManager,
 	return nil
 }
 
+func (pm *PluginManager) releaseUnchanged() {
+	for _, p := range pm.pluginMap {
+		if p.unchanged {
+			p.Refs.Dec()
+		}
+	}
+}
+
 func (pm *PluginManager) copyPlugin(info *fileInfo) (string, error) {
-	tmpDir := filepath.Join(filepath.Dir(info.file), "tmp", pm.dirName)
+	if len(pm.sigKeys) > 0 {
+		return pm.copyPluginPrivately(info)
+	}
+
+	tmpDir := filepath.Join(pm.tempRoot(filepath.Dir(info.file)), pm.dirName)
 	if err := os.MkdirAll(tmpDir, 0744); err != nil {
 		return "", err
 	}
@@ -170,39 +238,128 @@ func (pm *PluginManager) copyPlugin(info *fileInfo) (string, error) {
 	return dst, ioutil.WriteFile(dst, info.fileData, 0644)
 }
 
+// copyPluginPrivately copies a verified plugin into a new directory only accessible by the
+// current user, so that it cannot be replaced before it is opened. The directory is named
+// after pm.dirName, so that cleanTempDirs can find it.
+func (pm *PluginManager) copyPluginPrivately(info *fileInfo) (string, error) {
+	if pm.privateDir == "" {
+		root := pm.tempRoot(filepath.Dir(info.file))
+		if err := os.MkdirAll(root, 0700); err != nil {
+			return "", err
+		}
+		dir, err := os.MkdirTemp(root, pm.dirName+"-*")
+		if err != nil {
+			return "", err
+		}
+		pm.privateDir = dir
+	}
+
+	sum := xxHash32.Checksum(info.fileSha1[:], 0)
+	dst := filepath.Join(pm.privateDir, fmt.Sprintf("%s-%#8x%s", info.name, sum, hutils.FileNameExt))
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(info.fileData); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	return dst, f.Close()
+}
+
+// verifyCopy makes sure the copy of a verified plugin is still the verified one.
+func verifyCopy(file string, sum [sha256.Size]byte) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(data) != sum {
+		return fmt.Errorf("%s does not match the verified plugin file", file)
+	}
+	return nil
+}
+
 type pluginFuncItem struct {
-	symbol string
-	fn     interface{}
+	symbol   string
+	fn       interface{}
+	optional bool
 }
 
 func makePluginFuncItemList(p *Plugin) []pluginFuncItem {
 	return []pluginFuncItem{
-		{"OnLoad", &p.fOnLoad},
-		{"OnInit", &p.fOnInit},
-		{"OnFree", &p.fOnFree},
-		{"Export", &p.fExport},
-		{"Import", &p.fImport},
-		{"InvokeFunc", &p.InvokeFunc},
-		{"Reloadable", &p.fReloadable},
-		{"HotswapLiveFuncs", &p.hotswapLiveFuncs},
-		{"HotswapLiveTypes", &p.hotswapLiveTypes},
+		{"OnLoad", &p.fOnLoad, false},
+		{"OnInit", &p.fOnInit, false},
+		{"OnFree", &p.fOnFree, false},
+		{"Export", &p.fExport, false},
+		{"Import", &p.fImport, false},
+		{"InvokeFunc", &p.InvokeFunc, false},
+		{"Reloadable", &p.fReloadable, false},
+		{"HotswapLiveFuncs", &p.hotswapLiveFuncs, false},
+		{"HotswapLiveTypes", &p.hotswapLiveTypes, false},
+		{"OnMigrate", &p.fOnMigrate, true},
+		{"OnSnapshot", &p.fOnSnapshot, true},
+		{"OnLoadContext", &p.fOnLoadContext, true},
+		{"OnInitContext", &p.fOnInitContext, true},
+		{"InvokeFuncContext", &p.InvokeFuncContext, true},
+		{"HotswapMethods", &p.hotswapMethods, true},
+		{"Health", &p.fHealth, true},
 	}
 }
 
 func (pm *PluginManager) loadPlugin(info *fileInfo, data interface{}) error {
-	actual, err := pm.copyPlugin(info)
-	if err != nil {
-		return err
-	}
-
 	p := newPlugin()
 	p.Name = info.name
 	p.File = info.file
+	p.Version = info.version
 	p.FileSha1 = info.fileSha1
+	p.FileSha256 = info.fileSha256
 	p.When = pm.when
-	p.P, err = plugin.Open(actual)
-	if err != nil {
+	if m, err := hutils.FindManifest(info.fileData); err != nil {
 		return err
+	} else if m != nil {
+		p.Manifest = m
+		if pm.checkManifest {
+			if pm.hostDeps == nil {
+				pm.hostDeps = hostDependencies()
+			}
+			if err := checkManifest(m, pm.hostDeps); err != nil {
+				return err
+			}
+		}
+	} else if pm.checkManifest {
+		pm.Warnf("<hotswap> the plugin %s has no manifest", p.Name)
+	}
+	if pre, ok := pm.preopened[info.fileSha1]; ok {
+		p.P = pre.P
+		p.actualFile = pre.actualFile
+		p.borrowed = true
+		pm.Infof("<hotswap> reusing the plugin %s opened during validation", p.Name)
+	} else {
+		actual, err := pm.copyPlugin(info)
+		if err != nil {
+			return err
+		}
+		p.actualFile = actual
+		if len(pm.sigKeys) > 0 {
+			if err := verifyCopy(actual, info.fileSha256); err != nil {
+				pm.removeActualFile(p)
+				return err
+			}
+		}
+		start := time.Now()
+		p.P, err = plugin.Open(actual)
+		pm.emit(EventPluginOpened, p.Name, start, err)
+		if err != nil {
+			pm.removeActualFile(p)
+			return err
+		}
+		if pm.dryRun {
+			// Go plugins cannot be unloaded, and opening the same build again from another
+			// path fails, so every build opened during validation is kept for reuse. Only
+			// the handle is needed for that, so the copy can go right away.
+			pm.preopened[info.fileSha1] = p
+			pm.removeActualFile(p)
+		}
 	}
 	pm.cbOpen(p, data)
 
@@ -211,7 +368,9 @@ func (pm *PluginManager) loadPlugin(info *fileInfo, data interface{}) error {
 	for _, v := range a {
 		if err := p.Lookup(v.symbol, v.fn); err != nil {
 			if err == ErrNotExist {
-				missing = append(missing, v.symbol)
+				if !v.optional {
+					missing = append(missing, v.symbol)
+				}
 			} else {
 				return err
 			}
@@ -220,7 +379,9 @@ func (pm *PluginManager) loadPlugin(info *fileInfo, data interface{}) error {
 	if len(missing) > 0 {
 		return fmt.Errorf("missing functions: %s", strings.Join(missing, ", "))
 	}
+	pm.guardCalls(p)
 
+	var err error
 	p.reloadable, err = p.invokeReloadable()
 	if err != nil {
 		return err
@@ -246,48 +407,9 @@ func (pm *PluginManager) initDeps() error {
 		if p.unchanged {
 			continue
 		}
-		imp, err := p.invokeImport()
-		if err != nil {
+		if err := pm.wireDeps(p); err != nil {
 			return err
 		}
-		if isNil(imp) {
-			continue
-		}
-		impTyp := reflect.TypeOf(imp)
-		if impTyp.Kind() != reflect.Ptr || impTyp.Elem().Kind() != reflect.Struct {
-			return fmt.Errorf("the return value of %s.Import() must be a pointer to a struct", p.Name)
-		}
-		n := impTyp.Elem().NumField()
-		for i := 0; i < n; i++ {
-			field := impTyp.Elem().Field(i)
-			if ch := field.Name[0]; !unicode.IsUpper(rune(ch)) {
-				continue
-			}
-			if field.Tag.Get("hotswap") == "-" {
-				continue
-			}
-			if field.Anonymous {
-				return fmt.Errorf("field of the Import() object cannot be anonymous. field: %s, plugin: %s", field.Name, p.Name)
-			}
-			dep, ok := pm.pluginMap[name2key(field.Name)]
-			if !ok {
-				return fmt.Errorf("unknown dependency: %s. plugin: %s", field.Name, p.Name)
-			}
-			if !p.reloadable && dep.reloadable {
-				return fmt.Errorf("%s is NOT reloadable while its dependency, %s, is reloadable", p.Name, dep.Name)
-			}
-			if isNil(dep.exported) {
-				p.Deps = append(p.Deps, dep.Name)
-				continue
-			}
-			exportedVal := reflect.ValueOf(dep.exported)
-			if !exportedVal.Type().AssignableTo(field.Type) {
-				return fmt.Errorf("the return value %s.Export() is not assignable to %s.Import().%s",
-					dep.Name, p.Name, field.Name)
-			}
-			reflect.ValueOf(imp).Elem().Field(i).Set(exportedVal)
-			p.Deps = append(p.Deps, dep.Name)
-		}
 	}
 
 	for _, p := range pm.pluginMap {
@@ -318,6 +440,59 @@ func (pm *PluginManager) initDeps() error {
 	return nil
 }
 
+func (pm *PluginManager) wireDeps(p *Plugin) (errRet error) {
+	start := time.Now()
+	defer func() {
+		p.timings.Import = time.Since(start)
+		pm.emit(EventDepsWired, p.Name, start, errRet)
+	}()
+
+	imp, err := p.invokeImport()
+	if err != nil {
+		return err
+	}
+	if isNil(imp) {
+		return nil
+	}
+
+	impTyp := reflect.TypeOf(imp)
+	if impTyp.Kind() != reflect.Ptr || impTyp.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("the return value of %s.Import() must be a pointer to a struct", p.Name)
+	}
+	n := impTyp.Elem().NumField()
+	for i := 0; i < n; i++ {
+		field := impTyp.Elem().Field(i)
+		if ch := field.Name[0]; !unicode.IsUpper(rune(ch)) {
+			continue
+		}
+		if field.Tag.Get("hotswap") == "-" {
+			continue
+		}
+		if field.Anonymous {
+			return fmt.Errorf("field of the Import() object cannot be anonymous. field: %s, plugin: %s", field.Name, p.Name)
+		}
+		dep, ok := pm.pluginMap[name2key(field.Name)]
+		if !ok {
+			return fmt.Errorf("unknown dependency: %s. plugin: %s", field.Name, p.Name)
+		}
+		if !p.reloadable && dep.reloadable {
+			return fmt.Errorf("%s is NOT reloadable while its dependency, %s, is reloadable", p.Name, dep.Name)
+		}
+		if isNil(dep.exported) {
+			p.Deps = append(p.Deps, dep.Name)
+			continue
+		}
+		exportedVal := reflect.ValueOf(dep.exported)
+		if !exportedVal.Type().AssignableTo(field.Type) {
+			return fmt.Errorf("the return value %s.Export() is not assignable to %s.Import().%s",
+				dep.Name, p.Name, field.Name)
+		}
+		reflect.ValueOf(imp).Elem().Field(i).Set(exportedVal)
+		p.Deps = append(p.Deps, dep.Name)
+	}
+	return nil
+}
+
 func (pm *PluginManager) checkCyclicDependency(p *Plugin, visited map[*Plugin]struct{}) []*Plugin {
 	me := [1]*Plugin{p}
 	if _, ok := visited[p]; ok {
@@ -384,13 +559,83 @@ func (pm *PluginManager) orderPlugins() {
 	panic("something is wrong with PluginManager.orderedPlugins()")
 }
 
+func (pm *PluginManager) invokeEveryOnMigrate(oldManager *PluginManager) error {
+	for _, p := range pm.ordered {
+		if p.unchanged || p.fOnMigrate == nil {
+			continue
+		}
+		var old interface{}
+		if oldManager != nil {
+			if oldP := oldManager.FindPlugin(p.Name); oldP != nil {
+				var err error
+				if old, err = pm.snapshot(oldP); err != nil {
+					return err
+				}
+			}
+		}
+		pm.Debugf("<hotswap> invoking %s.OnMigrate", p.Name)
+		var migrated interface{}
+		err := pm.runHook(p, "OnMigrate", func(ctx context.Context) (err error) {
+			migrated, err = p.invokeOnMigrate(old)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		p.migrated = migrated
+	}
+	return nil
+}
+
+// snapshot returns the state of the outgoing version of a plugin, which is the return value of
+// its OnSnapshot, or the value its OnMigrate returned if it has no OnSnapshot.
+func (pm *PluginManager) snapshot(oldP *Plugin) (interface{}, error) {
+	if oldP.fOnSnapshot == nil {
+		return oldP.migrated, nil
+	}
+	pm.Debugf("<hotswap> invoking %s.OnSnapshot", oldP.Name)
+	var snapshot interface{}
+	err := pm.runHook(oldP, "OnSnapshot", func(ctx context.Context) (err error) {
+		snapshot, err = oldP.invokeOnSnapshot()
+		return err
+	})
+	return snapshot, err
+}
+
+func (pm *PluginManager) runHook(p *Plugin, hook string, f func(ctx context.Context) error) error {
+	if pm.hookTimeout <= 0 {
+		return f(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pm.hookTimeout)
+	defer cancel()
+	ch := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ch <- f(ctx)
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		// The hook keeps running. OnFree of the plugin waits for it.
+		p.hookDone = done
+		return fmt.Errorf("<hotswap:%s> %s timed out after %v", p.Name, hook, pm.hookTimeout)
+	}
+}
+
 func (pm *PluginManager) invokeEveryOnLoad(data interface{}) error {
-	invokeImpl := func(p *Plugin) (err error) {
+	invokeImpl := func(ctx context.Context, p *Plugin) (err error) {
 		defer func() {
 			if r := recover(); r != nil {
 				err = fmt.Errorf("<hotswap:%s> panic: %+v\n%s", p.Name, r, debug.Stack())
 			}
 		}()
+		if p.fOnLoadContext != nil {
+			pm.Debugf("<hotswap> invoking %s.OnLoadContext", p.Name)
+			return p.fOnLoadContext(ctx, data)
+		}
 		pm.Debugf("<hotswap> invoking %s.OnLoad", p.Name)
 		return p.fOnLoad(data)
 	}
@@ -399,7 +644,13 @@ func (pm *PluginManager) invokeEveryOnLoad(data interface{}) error {
 		if p.unchanged {
 			continue
 		}
-		if err := invokeImpl(p); err != nil {
+		start := time.Now()
+		err := pm.runHook(p, "OnLoad", func(ctx context.Context) error {
+			return invokeImpl(ctx, p)
+		})
+		p.timings.OnLoad = time.Since(start)
+		pm.emit(EventOnLoadInvoked, p.Name, start, err)
+		if err != nil {
 			return err
 		}
 	}
@@ -408,12 +659,16 @@ func (pm *PluginManager) invokeEveryOnLoad(data interface{}) error {
 }
 
 func (pm *PluginManager) invokeEveryOnInit() error {
-	invokeImpl := func(p *Plugin) (err error) {
+	invokeImpl := func(ctx context.Context, p *Plugin) (err error) {
 		defer func() {
 			if r := recover(); r != nil {
 				err = fmt.Errorf("<hotswap:%s> panic: %+v\n%s", p.Name, r, debug.Stack())
 			}
 		}()
+		if p.fOnInitContext != nil {
+			pm.Debugf("<hotswap> invoking %s.OnInitContext", p.Name)
+			return p.fOnInitContext(ctx, &pm.Vault)
+		}
 		pm.Debugf("<hotswap> invoking %s.OnInit", p.Name)
 		return p.fOnInit(&pm.Vault)
 	}
@@ -422,7 +677,13 @@ func (pm *PluginManager) invokeEveryOnInit() error {
 		if p.unchanged {
 			continue
 		}
-		if err := invokeImpl(p); err != nil {
+		start := time.Now()
+		err := pm.runHook(p, "OnInit", func(ctx context.Context) error {
+			return invokeImpl(ctx, p)
+		})
+		p.timings.OnInit = time.Since(start)
+		pm.emit(EventOnInitInvoked, p.Name, start, err)
+		if err != nil {
 			return err
 		}
 	}
@@ -437,6 +698,9 @@ func (pm *PluginManager) setupVault() error {
 			return fmt.Errorf("something is wrong with HotswapLiveFuncs(). plugin: %s", p.Name)
 		}
 		for k, v := range liveFuncs {
+			if pm.namespaced {
+				k = p.Name + vault.NamespaceSeparator + k
+			}
 			if _, ok := pm.LiveFuncs[k]; !ok {
 				pm.LiveFuncs[k] = v
 				continue
@@ -455,12 +719,30 @@ func (pm *PluginManager) setupVault() error {
 		}
 	}
 
+	for k, fn := range pm.LiveFuncs {
+		name := k
+		if pm.namespaced {
+			name = k[strings.Index(k, vault.NamespaceSeparator)+1:]
+		}
+		sig, ok := pm.liveFuncSigs[name]
+		if !ok {
+			continue
+		}
+		if expected, actual := reflect.TypeOf(sig), reflect.TypeOf(fn); actual != expected {
+			return fmt.Errorf("unexpected signature of the live function %s. expected: %s, actual: %s",
+				k, expected, actual)
+		}
+	}
+
 	for i, p := range pm.ordered {
 		liveTypes := p.hotswapLiveTypes()
 		if isNil(liveTypes) {
 			return fmt.Errorf("something is wrong with HotswapLiveTypes(). plugin: %s", p.Name)
 		}
 		for k, v := range liveTypes {
+			if pm.namespaced {
+				k = p.Name + vault.NamespaceSeparator + k
+			}
 			if _, ok := pm.LiveTypes[k]; !ok {
 				pm.LiveTypes[k] = v
 				continue
@@ -483,10 +765,7 @@ func (pm *PluginManager) setupVault() error {
 }
 
 func (pm *PluginManager) invokeEveryOnFree() {
-	invokeImpl := func(p *Plugin) {
-		if v := p.Refs.Dec(); v > 0 {
-			return
-		}
+	free := func(p *Plugin) {
 		defer func() {
 			if r := recover(); r != nil {
 				pm.Errorf("<hotswap:%s> panic: %+v\n%s", p.Name, r, debug.Stack())
@@ -494,9 +773,28 @@ func (pm *PluginManager) invokeEveryOnFree() {
 		}()
 		pm.Debugf("<hotswap> invoking %s.OnFree", p.Name)
 		p.freeOnce.Do(func() {
+			defer pm.removeActualFile(p)
 			p.fOnFree()
 		})
 	}
+	invokeImpl := func(p *Plugin) {
+		if v := p.Refs.Dec(); v > 0 {
+			return
+		}
+		if p.hookDone != nil {
+			select {
+			case <-p.hookDone:
+			default:
+				pm.Warnf("<hotswap:%s> OnFree is deferred until the timed-out hook returns", p.Name)
+				go func() {
+					<-p.hookDone
+					free(p)
+				}()
+				return
+			}
+		}
+		free(p)
+	}
 
 	all := pm.Plugins()
 	if len(all) > 0 {
@@ -520,8 +818,12 @@ func (pm *PluginManager) Plugins() []*Plugin {
 
 func (pm *PluginManager) InvokeEach(name string, params ...interface{}) {
 	invokeImpl := func(p *Plugin) {
+		if p.Quarantined() {
+			return
+		}
 		defer func() {
 			if r := recover(); r != nil {
+				p.countPanic()
 				pm.Errorf("<hotswap:%s> panic: %+v\n%s", p.Name, r, debug.Stack())
 			}
 		}()
@@ -538,8 +840,12 @@ func (pm *PluginManager) InvokeEach(name string, params ...interface{}) {
 
 func (pm *PluginManager) InvokeEachBackward(name string, params ...interface{}) {
 	invokeImpl := func(p *Plugin) {
+		if p.Quarantined() {
+			return
+		}
 		defer func() {
 			if r := recover(); r != nil {
+				p.countPanic()
 				pm.Errorf("<hotswap:%s> panic: %+v\n%s", p.Name, r, debug.Stack())
 			}
 		}()
@@ -554,11 +860,116 @@ func (pm *PluginManager) InvokeEachBackward(name string, params ...interface{})
 	}
 }
 
+type InvokeResult struct {
+	Plugin string
+	Value  interface{}
+	Err    error
+}
+
+type InvokeResults []InvokeResult
+
+// Err returns an error describing every failed invocation, or nil if all succeeded.
+func (a InvokeResults) Err() error {
+	var msgs []string
+	for _, r := range a {
+		if r.Err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", r.Plugin, r.Err))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d plugin(s) failed. %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+func invokeForResult(p *Plugin, name string, params ...interface{}) (r InvokeResult) {
+	r.Plugin = p.Name
+	defer func() {
+		if x := recover(); x != nil {
+			p.countPanic()
+			r.Err = fmt.Errorf("<hotswap:%s> panic: %+v\n%s", p.Name, x, debug.Stack())
+		}
+	}()
+	r.Value, r.Err = p.InvokeFunc(name, params...)
+	return
+}
+
+// InvokeEachWithResults is like InvokeEach, but returns the results instead of logging the errors.
+func (pm *PluginManager) InvokeEachWithResults(name string, params ...interface{}) InvokeResults {
+	all := pm.Plugins()
+	results := make(InvokeResults, 0, len(all))
+	for _, p := range all {
+		results = append(results, invokeForResult(p, name, params...))
+	}
+	return results
+}
+
+// InvokeEachBackwardWithResults is like InvokeEachBackward, but returns the results instead of logging the errors.
+func (pm *PluginManager) InvokeEachBackwardWithResults(name string, params ...interface{}) InvokeResults {
+	all := pm.Plugins()
+	results := make(InvokeResults, 0, len(all))
+	for i := len(all) - 1; i >= 0; i-- {
+		results = append(results, invokeForResult(all[i], name, params...))
+	}
+	return results
+}
+
+// InvokeEachConcurrently invokes the function of every plugin layer by layer. A plugin
+// belongs to the layer next to the deepest of its dependencies, and the plugins of a layer
+// run in parallel with at most maxWorkers goroutines. The results are in the same order
+// as Plugins().
+func (pm *PluginManager) InvokeEachConcurrently(maxWorkers int, name string, params ...interface{}) InvokeResults {
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+
+	all := pm.Plugins()
+	results := make(InvokeResults, len(all))
+	for _, layer := range pm.layers() {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, maxWorkers)
+		for _, i := range layer {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				results[i] = invokeForResult(all[i], name, params...)
+			}(i)
+		}
+		wg.Wait()
+	}
+	return results
+}
+
+func (pm *PluginManager) layers() [][]int {
+	depth := make(map[string]int)
+	var layers [][]int
+	for i, p := range pm.ordered {
+		d := 0
+		for _, dep := range p.Deps {
+			if v, ok := depth[name2key(dep)]; ok && v+1 > d {
+				d = v + 1
+			}
+		}
+		depth[name2key(p.Name)] = d
+		for len(layers) <= d {
+			layers = append(layers, nil)
+		}
+		layers[d] = append(layers[d], i)
+	}
+	return layers
+}
+
 type fileInfo struct {
-	name     string
-	file     string
-	fileData []byte
-	fileSha1 [sha1.Size]byte
+	name       string
+	file       string
+	version    string
+	fileData   []byte
+	fileSha1   [sha1.Size]byte
+	fileSha256 [sha256.Size]byte
 }
 
 type fileInfoMap struct {
@@ -621,29 +1032,61 @@ func pluginName(file string) string {
 	return strings.TrimSuffix(filepath.Base(file), hutils.FileNameExt)
 }
 
-func (x fileInfoMap) add(file string, fileData []byte) {
-	name := pluginName(file)
-	fileSha1 := sha1.Sum(fileData)
+func (x fileInfoMap) add(e PluginEntry, fileData []byte) {
+	name, file := e.Name, e.Path
 	info := &fileInfo{
-		name:     name,
-		file:     file,
-		fileData: fileData,
-		fileSha1: fileSha1,
+		name:       name,
+		file:       file,
+		version:    e.Version,
+		fileData:   fileData,
+		fileSha1:   sha1.Sum(fileData),
+		fileSha256: sha256.Sum256(fileData),
 	}
 	k := name2key(name)
 	x.m[k] = info
 }
 
-func buildFileInfoMap(files []string) (fileInfoMap, error) {
+func (pm *PluginManager) readFile(file string) ([]byte, error) {
+	if pm.source != nil {
+		return pm.source.ReadFile(file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+func (pm *PluginManager) buildFileInfoMap(entries []PluginEntry) (fileInfoMap, error) {
 	x := fileInfoMap{
 		m: make(map[string]*fileInfo),
 	}
-	for _, file := range files {
-		data, err := ioutil.ReadFile(file)
+	for _, e := range entries {
+		start := time.Now()
+		data, err := pm.readFile(e.Path)
 		if err != nil {
 			return x, err
 		}
-		x.add(file, data)
+		x.add(e, data)
+		if len(pm.sigKeys) > 0 {
+			if err := pm.verifySignature(x.m[name2key(e.Name)]); err != nil {
+				pm.emit(EventFileHashed, e.Name, start, err)
+				return x, err
+			}
+		}
+		pm.emit(EventFileHashed, e.Name, start, nil)
 	}
 	return x, nil
 }
+
+func (pm *PluginManager) verifySignature(info *fileInfo) error {
+	file := info.file
+	sigFile := hutils.SignatureFile(file)
+	data, err := pm.readFile(sigFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("the signature of %s is missing. expected: %s", file, sigFile)
+		}
+		return err
+	}
+	if err := hutils.VerifyPlugin(pm.sigKeys, info.name, info.version, info.fileSha256, data); err != nil {
+		return fmt.Errorf("failed to verify the signature of %s. err: %w", file, err)
+	}
+	return nil
+}
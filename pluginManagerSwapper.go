@@ -2,9 +2,10 @@ package hotswap
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/sha1"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"path/filepath"
 	"runtime/debug"
 	"sort"
@@ -39,29 +40,85 @@ type PluginManagerSwapper struct {
 	current atomic.Value
 
 	opts struct {
-		pluginDir      string
-		newExt         func() interface{}
-		reloadCallback ReloadCallback
-		freeDelay      time.Duration
-		whitelist      pluginWhitelist
+		pluginDir        string
+		newExt           func() interface{}
+		reloadCallback   ReloadCallback
+		reloadHooks      []ReloadHook
+		freeDelay        time.Duration
+		whitelist        pluginWhitelist
+		watchInterval    time.Duration
+		settleDelay      time.Duration
+		rollbackDepth    int
+		liveFuncSigs     map[string]interface{}
+		namespaced       bool
+		hookTimeout      time.Duration
+		leaseDraining    bool
+		sigKeys          []ed25519.PublicKey
+		checkManifest    bool
+		tempDir          string
+		tempCleanup      bool
+		source           PluginSource
+		versionPolicy    VersionPolicy
+		metrics          Metrics
+		panicPolicy      PanicPolicy
+		quarantineN      int
+		healthGate       bool
+		healthTimeout    time.Duration
+		healthInterval   time.Duration
+		rollbackWindow   time.Duration
+		rollbackInterval time.Duration
+		rollbackProbe    RollbackProbe
 	}
 
 	staticPlugins map[string]*StaticPlugin
 	reloadCounter int64
+	generation    int64
+	history       []*PluginManager
+	events        eventBus
+	metricsState  metricsState
+	preopened     map[[sha1.Size]byte]*Plugin
+	window        *rollbackWindow
+	lastWindow    *rollbackWindow
 
 	mu sync.Mutex
 }
 
 func NewPluginManagerSwapper(pluginDir string, opts ...Option) *PluginManagerSwapper {
-	swapper := &PluginManagerSwapper{Logger: slog.NewDevelopmentConfig().MustBuild()}
+	swapper := &PluginManagerSwapper{
+		Logger:    slog.NewDevelopmentConfig().MustBuild(),
+		preopened: make(map[[sha1.Size]byte]*Plugin),
+	}
 	swapper.opts.pluginDir = pluginDir
 	swapper.opts.freeDelay = time.Minute * 5
+	swapper.opts.watchInterval = time.Second
+	swapper.opts.settleDelay = time.Second * 3
+	swapper.opts.healthTimeout = time.Second * 10
+	swapper.opts.healthInterval = time.Second * 30
 	for _, opt := range opts {
 		opt(swapper)
 	}
 	return swapper
 }
 
+func (sw *PluginManagerSwapper) newPluginManager() *PluginManager {
+	pm := newPluginManager(sw.Logger, sw.opts.newExt)
+	pm.emit = sw.emit
+	pm.preopened = sw.preopened
+	pm.liveFuncSigs = sw.opts.liveFuncSigs
+	pm.namespaced = sw.opts.namespaced
+	pm.hookTimeout = sw.opts.hookTimeout
+	pm.sigKeys = sw.opts.sigKeys
+	pm.checkManifest = sw.opts.checkManifest
+	pm.source = sw.source()
+	pm.metrics = sw.opts.metrics
+	pm.panicPolicy = sw.opts.panicPolicy
+	pm.quarantineAfter = sw.opts.quarantineN
+	pm.watchPanics = sw.opts.rollbackWindow > 0
+	pm.tempDir = sw.tempRoot()
+	pm.tempCleanup = sw.opts.tempCleanup
+	return pm
+}
+
 func (sw *PluginManagerSwapper) ResetPluginDir(pluginDir string) {
 	sw.opts.pluginDir = pluginDir
 }
@@ -81,91 +138,223 @@ func (sw *PluginManagerSwapper) LoadPlugins(data interface{}) (Details, error) {
 		return sw.loadStaticPlugins(data, cbs)
 	}
 
+	if sw.opts.tempCleanup {
+		sw.cleanTempDirs()
+	}
 	return sw.loadPluginsImpl(data, cbs)
 }
 
 func (sw *PluginManagerSwapper) loadPluginsImpl(data interface{}, cbs []ReloadCallback) (Details, error) {
-	var absDir string
-	if err := hutils.FindDirectory(sw.opts.pluginDir, "pluginDir"); err != nil {
-		return nil, err
-	} else if absDir, err = filepath.Abs(sw.opts.pluginDir); err != nil {
+	entries, err := sw.listPlugins(sw.source())
+	if err != nil {
 		return nil, err
 	}
+	return sw.loadPluginFiles(entries, nil, data, cbs)
+}
+
+func (sw *PluginManagerSwapper) source() PluginSource {
+	if sw.opts.source != nil {
+		return sw.opts.source
+	}
+	return DirSource(sw.opts.pluginDir)
+}
 
-	a, err := ioutil.ReadDir(absDir)
+func (sw *PluginManagerSwapper) listPlugins(src PluginSource) ([]PluginEntry, error) {
+	all, err := src.List()
 	if err != nil {
 		return nil, err
 	}
-	var files []string
+	entries, err := sw.filterPlugins(all)
+	if err != nil {
+		return nil, err
+	}
+	if len(sw.opts.whitelist) == 0 {
+		return entries, nil
+	}
+
 	var found = make(map[string]struct{})
-	for _, fi := range a {
-		if fi.IsDir() {
-			continue
-		}
-		if strings.HasSuffix(fi.Name(), hutils.FileNameExt) {
-			if len(sw.opts.whitelist) > 0 {
-				if name := pluginName(fi.Name()); sw.opts.whitelist.Contains(name) {
-					found[name] = struct{}{}
-				} else {
-					continue
-				}
+	for _, e := range entries {
+		found[e.Name] = struct{}{}
+	}
+	if len(found) != len(sw.opts.whitelist) {
+		var missing []string
+		for _, v := range sw.opts.whitelist {
+			if _, ok := found[v]; !ok {
+				missing = append(missing, v)
 			}
-			files = append(files, filepath.Join(absDir, fi.Name()))
 		}
+		return nil, errors.New("cannot find the following plugin(s): " + hutils.Join(missing...))
 	}
+	return entries, nil
+}
+
+func (sw *PluginManagerSwapper) filterPlugins(all []PluginEntry) ([]PluginEntry, error) {
 	if len(sw.opts.whitelist) > 0 {
-		if len(found) != len(sw.opts.whitelist) {
-			var missing []string
-			for _, v := range sw.opts.whitelist {
-				if _, ok := found[v]; !ok {
-					missing = append(missing, v)
+		var a []PluginEntry
+		for _, e := range all {
+			if sw.opts.whitelist.Contains(e.Name) {
+				a = append(a, e)
+			}
+		}
+		all = a
+	}
+	return sw.selectVersions(all)
+}
+
+func (sw *PluginManagerSwapper) reloadPluginsImpl(names []string, data interface{}, cbs []ReloadCallback) (Details, error) {
+	oldManager := sw.Current()
+	if oldManager == nil {
+		return nil, errors.New("no plugin has been loaded yet")
+	}
+
+	rebuild := make(map[string]struct{})
+	for _, name := range names {
+		if oldManager.FindPlugin(name) == nil {
+			return nil, fmt.Errorf("unknown plugin: %s", name)
+		}
+		rebuild[name2key(name)] = struct{}{}
+	}
+	for {
+		n := len(rebuild)
+		for k, p := range oldManager.pluginMap {
+			if _, ok := rebuild[k]; ok {
+				continue
+			}
+			for _, depName := range p.Deps {
+				if _, ok := rebuild[name2key(depName)]; ok {
+					rebuild[k] = struct{}{}
+					break
 				}
 			}
-			return nil, errors.New("cannot find the following plugin(s): " + hutils.Join(missing...))
+		}
+		if len(rebuild) == n {
+			break
 		}
 	}
 
-	return sw.loadPluginFiles(files, data, cbs)
+	all, err := sw.listPlugins(sw.source())
+	if err != nil {
+		return nil, err
+	}
+	var entries []PluginEntry
+	for _, e := range all {
+		if _, ok := rebuild[name2key(e.Name)]; ok {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) != len(rebuild) {
+		found := make(map[string]struct{})
+		for _, e := range entries {
+			found[name2key(e.Name)] = struct{}{}
+		}
+		var missing []string
+		for k := range rebuild {
+			if _, ok := found[k]; !ok {
+				missing = append(missing, oldManager.pluginMap[k].Name)
+			}
+		}
+		sort.Strings(missing)
+		return nil, errors.New("cannot find the following plugin(s): " + hutils.Join(missing...))
+	}
+
+	var keep []string
+	for k := range oldManager.pluginMap {
+		if _, ok := rebuild[k]; !ok {
+			keep = append(keep, k)
+		}
+	}
+	return sw.loadPluginFiles(entries, keep, data, cbs)
 }
 
-func (sw *PluginManagerSwapper) loadPluginFiles(files []string, data interface{}, cbs []ReloadCallback) (Details, error) {
-	if len(files) == 0 {
+func (sw *PluginManagerSwapper) loadPluginFiles(entries []PluginEntry, keep []string, data interface{}, cbs []ReloadCallback) (Details, error) {
+	if len(entries) == 0 {
 		return nil, nil
 	}
 
+	start := time.Now()
+	sw.emit(EventReloadStarted, "", start, nil)
 	oldManager := sw.Current()
-	newManager := newPluginManager(sw.Logger, sw.opts.newExt)
-	if err := newManager.loadPlugins(files, oldManager, data); err != nil {
+	newManager := sw.newPluginManager()
+	failedStep := trackFailedStep(newManager)
+	if err := newManager.loadPluginsKeeping(entries, keep, oldManager, data); err != nil {
+		sw.abortReload(newManager, start, failedStep(), err)
 		return nil, err
 	}
+	newManager.report = newReloadReport(newManager, oldManager, sw.generation+1, start)
 	if err := invokeReloadCallbacks(cbs, newManager, oldManager); err != nil {
+		sw.abortReload(newManager, start, failedStep(), err)
 		return nil, err
 	}
-
-	result := make(map[string]string)
-	for _, f := range files {
-		p := newManager.FindPlugin(pluginName(f))
-		if p.Note != "" {
-			result[p.File] = p.Note
-		} else {
-			result[p.File] = "ok"
-		}
+	if err := sw.gateHealth(newManager); err != nil {
+		newManager.invokeEveryOnFree()
+		sw.abortReload(newManager, start, failedStep(), err)
+		return nil, err
 	}
+	if err := sw.prepareReload(newManager, oldManager); err != nil {
+		newManager.invokeEveryOnFree()
+		sw.abortReload(newManager, start, failedStep(), err)
+		return nil, err
+	}
+
+	sw.generation++
+	result := newManager.report.Details()
+	sw.endRollbackWindow()
 	if oldManager != nil {
-		go func() {
-			delay := minFreeDelay
-			if minFreeDelay < sw.opts.freeDelay {
-				delay = sw.opts.freeDelay
-			}
-			time.Sleep(delay)
-			oldManager.invokeEveryOnFree()
-		}()
+		sw.history = append(sw.history, oldManager)
+	}
+	if oldManager != nil && sw.opts.rollbackWindow > 0 {
+		newManager.report.window = newRollbackWindow(newManager)
 	}
 
 	sw.current.Store(newManager)
+	sw.dropPreopened(newManager)
+	sw.commitReload(newManager, oldManager)
+	sw.emit(EventReloadCommitted, "", start, nil)
+
+	if w := newManager.report.window; w != nil {
+		sw.startRollbackWindow(w)
+		sw.trimHistory(1)
+	} else {
+		sw.trimHistory(0)
+	}
 	return result, nil
 }
 
+// trimHistory frees the generations beyond the rollback depth plus extra.
+func (sw *PluginManagerSwapper) trimHistory(extra int) {
+	if n := len(sw.history) - sw.opts.rollbackDepth - extra; n > 0 {
+		for _, m := range sw.history[:n] {
+			sw.scheduleFree(m)
+		}
+		sw.history = append([]*PluginManager(nil), sw.history[n:]...)
+	}
+}
+
+func (sw *PluginManagerSwapper) scheduleFree(pluginManager *PluginManager) {
+	go func() {
+		delay := minFreeDelay
+		if minFreeDelay < sw.opts.freeDelay {
+			delay = sw.opts.freeDelay
+		}
+		if sw.opts.leaseDraining {
+			drained := pluginManager.leases.retire()
+			time.Sleep(minFreeDelay)
+			select {
+			case <-drained:
+			case <-time.After(delay - minFreeDelay):
+				sw.Warnf("<hotswap> freeing the generation loaded at %s with %d lease(s) still held",
+					pluginManager.when.Format(time.RFC3339), pluginManager.leases.count())
+			}
+		} else {
+			time.Sleep(delay)
+		}
+		start := time.Now()
+		pluginManager.invokeEveryOnFree()
+		sw.emit(EventGenerationFreed, "", start, nil)
+		sw.recordPluginRefs(pluginManager)
+	}()
+}
+
 func invokeReloadCallbacks(cbs []ReloadCallback, newManager, oldManager *PluginManager) error {
 	for _, cb := range cbs {
 		if cb == nil {
@@ -209,6 +398,64 @@ func (sw *PluginManagerSwapper) ReloadWithCallback(data interface{}, extra Reloa
 	return details, err
 }
 
+// ReloadPlugins rebuilds the named plugins and the plugins depending on them. Every other
+// plugin is carried over from the current PluginManager, even if its file has changed.
+func (sw *PluginManagerSwapper) ReloadPlugins(names []string, data interface{}) (Details, error) {
+	if sw.staticPlugins != nil {
+		return nil, errors.New("running under static linking mode")
+	}
+	if len(names) == 0 {
+		return nil, errors.New("names cannot be empty")
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	cbs := []ReloadCallback{sw.opts.reloadCallback}
+	details, err := sw.reloadPluginsImpl(names, data, cbs)
+	if err == nil {
+		atomic.AddInt64(&sw.reloadCounter, 1)
+	}
+	return details, err
+}
+
+// Rollback makes the previous PluginManager current again and schedules the freeing of the
+// abandoned one. Only the generations kept alive by WithRollbackDepth or by a running
+// rollback window can be restored. Rollback ends the running rollback window.
+func (sw *PluginManagerSwapper) Rollback() error {
+	if sw.staticPlugins != nil {
+		return errors.New("running under static linking mode")
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.endRollbackWindow()
+	err := sw.rollbackLocked()
+	sw.trimHistory(0)
+	return err
+}
+
+func (sw *PluginManagerSwapper) rollbackLocked() error {
+	n := len(sw.history)
+	if n == 0 {
+		return errors.New("no previous generation to roll back to")
+	}
+	prev := sw.history[n-1]
+	abandoned := sw.Current()
+	start := time.Now()
+	if err := sw.prepareReload(prev, abandoned); err != nil {
+		// Nothing is discarded, so it is not an aborted reload. prev stays in the history.
+		sw.emit(EventRollbackVetoed, "", start, err)
+		sw.Warnf("<hotswap> the rollback is vetoed. err: %v", err)
+		return err
+	}
+	sw.history = sw.history[:n-1]
+	sw.current.Store(prev)
+	sw.commitReload(prev, abandoned)
+	sw.scheduleFree(abandoned)
+	sw.Infof("<hotswap> rolled back to the generation loaded at %s", prev.when.Format(time.RFC3339))
+	return nil
+}
+
 func (sw *PluginManagerSwapper) ReloadCounter() int64 {
 	return atomic.LoadInt64(&sw.reloadCounter)
 }
@@ -253,6 +500,63 @@ func WithFreeDelay(d time.Duration) Option {
 	}
 }
 
+// WithSignatureVerification requires every plugin file to have a detached signature, the
+// .sig file produced by "hotswap sign", made with the private key of one of the keys.
+func WithSignatureVerification(keys ...ed25519.PublicKey) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.sigKeys = keys
+	}
+}
+
+// WithManifestCheck rejects a plugin before opening it if its manifest shows that it was
+// built with a different Go version or different versions of the modules shared with the host.
+func WithManifestCheck() Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.checkManifest = true
+	}
+}
+
+// WithLeaseDraining makes a replaced PluginManager freed as soon as all the leases handed
+// out by Acquire are released. The free delay becomes an upper bound, while the minimum free
+// delay (15 seconds) still applies, so callers of Current are not affected.
+func WithLeaseDraining() Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.leaseDraining = true
+	}
+}
+
+// WithPluginSource loads plugins from src instead of the plugin directory. Unless WithTempDir
+// is also used, the plugin files are copied into the hotswap subdirectory of os.TempDir().
+func WithPluginSource(src PluginSource) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.source = src
+	}
+}
+
+// WithVersionPolicy sets the VersionPolicy selecting the build to load when the PluginSource
+// lists more than one build of a plugin. The default value is CurrentVersion().
+func WithVersionPolicy(policy VersionPolicy) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.versionPolicy = policy
+	}
+}
+
+// WithTempDir sets the directory where plugin files are copied to before being opened.
+// The default value is the tmp subdirectory of the plugin directory.
+func WithTempDir(dir string) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.tempDir = dir
+	}
+}
+
+// WithTempCleanup removes the copy of a plugin file once the plugin is freed. LoadPlugins also
+// removes the directories left in the temp directory by dead processes.
+func WithTempCleanup() Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.tempCleanup = true
+	}
+}
+
 // WithReloadCallback sets the callback function of reloading.
 func WithReloadCallback(cb ReloadCallback) Option {
 	return func(mgr *PluginManagerSwapper) {
@@ -260,6 +564,63 @@ func WithReloadCallback(cb ReloadCallback) Option {
 	}
 }
 
+// WithReloadHook adds two-phase hooks of reloading. See ReloadHook for details.
+func WithReloadHook(hooks ...ReloadHook) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.reloadHooks = append(mgr.opts.reloadHooks, hooks...)
+	}
+}
+
+// WithMetrics makes the PluginManagerSwapper report its measurements to m.
+func WithMetrics(m Metrics) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.metrics = m
+	}
+}
+
+// WithPanicPolicy sets what happens when InvokeFunc or InvokeFuncContext of a plugin panics.
+// The default policy is PanicPropagate.
+func WithPanicPolicy(policy PanicPolicy) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.panicPolicy = policy
+	}
+}
+
+// WithPanicQuarantine sets the panic policy to PanicQuarantine. A plugin is quarantined after
+// it panics n times, and all further calls to it return ErrPluginQuarantined.
+func WithPanicQuarantine(n int) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.panicPolicy = PanicQuarantine
+		mgr.opts.quarantineN = n
+	}
+}
+
+// WithHealthGate makes a reload fail if any of the new plugins is unhealthy. See PluginManager.CheckHealth.
+func WithHealthGate() Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.healthGate = true
+	}
+}
+
+// WithHealthCheckTimeout sets the time limit of a health check of all plugins. The default value is 10 seconds.
+func WithHealthCheckTimeout(d time.Duration) Option {
+	return func(mgr *PluginManagerSwapper) {
+		if d > 0 {
+			mgr.opts.healthTimeout = d
+		}
+	}
+}
+
+// WithHealthCheckInterval sets the interval of the health checks made by MonitorHealth.
+// The default value is 30 seconds.
+func WithHealthCheckInterval(d time.Duration) Option {
+	return func(mgr *PluginManagerSwapper) {
+		if d > 0 {
+			mgr.opts.healthInterval = d
+		}
+	}
+}
+
 // WithExtensionNewer sets the function used to create a new object for PluginManager.Vault.Extension.
 func WithExtensionNewer(newExt func() interface{}) Option {
 	return func(mgr *PluginManagerSwapper) {
@@ -280,3 +641,72 @@ func WithWhitelist(pluginNames ...string) Option {
 		mgr.opts.whitelist = pluginNames
 	}
 }
+
+// WithWatchInterval sets how often Watch polls the plugin directory. The default value is 1 second.
+func WithWatchInterval(d time.Duration) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.watchInterval = d
+	}
+}
+
+// WithSettleDelay sets how long plugin files must stay untouched before Watch reloads them.
+// The default value is 3 seconds.
+func WithSettleDelay(d time.Duration) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.settleDelay = d
+	}
+}
+
+// WithRollbackDepth sets the number of previous generations kept alive for Rollback.
+// The default value is 0, which means every replaced generation is freed after the free delay.
+func WithRollbackDepth(n int) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.rollbackDepth = n
+	}
+}
+
+// WithRollbackWindow makes every reload watch the new plugins in the background for the
+// duration of window, checking them at the given interval. If a plugin panics, a health check
+// fails or the probe set by WithRollbackProbe fails within the window, the previous generation,
+// which is kept alive until then, is restored. Use WaitRollbackWindow of the ReloadReport,
+// available as Current().Report() right after the reload, to learn the outcome.
+func WithRollbackWindow(window, interval time.Duration) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.rollbackWindow = window
+		mgr.opts.rollbackInterval = interval
+	}
+}
+
+// WithRollbackProbe sets the host-supplied check made during the rollback window.
+func WithRollbackProbe(probe RollbackProbe) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.rollbackProbe = probe
+	}
+}
+
+// WithLiveFuncSignatures declares the signatures of live functions with typed nil values,
+// e.g. (func(string) error)(nil). A reload fails if a live function does not match its signature.
+func WithLiveFuncSignatures(sigs map[string]interface{}) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.liveFuncSigs = sigs
+	}
+}
+
+// WithNamespacedLiveSymbols keys live functions and live types as pluginName/name, so that
+// different plugins can use the same names. Use Vault.FindFunc and Vault.FindType to look them up.
+func WithNamespacedLiveSymbols() Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.namespaced = true
+	}
+}
+
+// WithHookTimeout limits the running time of every OnSnapshot, OnMigrate, OnLoad and OnInit call.
+// A hook that runs out of time fails the reload. The context passed to OnLoadContext and
+// OnInitContext is canceled at the deadline. Go cannot stop the hook, which keeps running in
+// the background, so OnFree of its plugin is not called until the hook returns. The default
+// value is 0, which means no limit.
+func WithHookTimeout(d time.Duration) Option {
+	return func(mgr *PluginManagerSwapper) {
+		mgr.opts.hookTimeout = d
+	}
+}
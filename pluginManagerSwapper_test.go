@@ -1,13 +1,26 @@
 package hotswap
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/edwingeng/hotswap/internal/hutils"
+	"github.com/edwingeng/hotswap/vault"
+	"go.uber.org/atomic"
 )
 
 func newSwapper(pluginDir string, opts ...Option) *PluginManagerSwapper {
@@ -255,3 +268,670 @@ func TestFormatDetails(t *testing.T) {
 		}
 	}
 }
+
+func TestPluginManagerSwapper_ReloadPlugins(t *testing.T) {
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "ReloadPlugins", pluginNames...)
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log))
+	if _, err := swapper.ReloadPlugins([]string{"snow"}, log); err == nil {
+		t.Fatal("ReloadPlugins should fail before a successful call of LoadPlugins")
+	}
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swapper.ReloadPlugins([]string{"jon"}, log); err == nil {
+		t.Fatal("ReloadPlugins should fail when a plugin name is unknown")
+	}
+
+	buildArgs := []string{"--", "-ldflags", "-X main.CompileTimeString=stark"}
+	preparePluginGroupImpl(t, buildArgs, "ReloadPlugins", false, pluginNames...)
+	details, err := swapper.ReloadPlugins([]string{"snow"}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range details {
+		switch name2key(pluginName(k)) {
+		case "arya":
+			if v != "unchanged" {
+				t.Fatalf(`unexpected result. file: %s, result: %s`, k, v)
+			}
+		case "snow":
+			if v != "ok" {
+				t.Fatalf(`unexpected result. file: %s, result: %s`, k, v)
+			}
+		default:
+			panic("impossible")
+		}
+	}
+	for _, pr := range swapper.Current().Report().Plugins {
+		switch pr.Name {
+		case "arya":
+			if pr.Status != PluginStatusUnchanged || pr.Generation != 1 || pr.OldSha1 != pr.NewSha1 {
+				t.Fatalf("unexpected report: %+v", pr)
+			}
+		case "snow":
+			if pr.Status != PluginStatusOK || pr.Generation != 2 || pr.OldSha1 == pr.NewSha1 {
+				t.Fatalf("unexpected report: %+v", pr)
+			}
+		}
+	}
+	for _, p := range swapper.Current().Plugins() {
+		compileTimeString := ""
+		if err := p.Lookup("CompileTimeString", &compileTimeString); err != nil {
+			t.Fatal(err)
+		}
+		switch p.Name {
+		case "arya":
+			if compileTimeString != "" {
+				t.Fatal("arya should not be reloaded")
+			}
+		case "snow":
+			if compileTimeString != "stark" {
+				t.Fatal("snow should be reloaded")
+			}
+		default:
+			panic("impossible")
+		}
+	}
+}
+
+func TestPluginManagerSwapper_Rollback(t *testing.T) {
+	oldMinFreeDelay := minFreeDelay
+	minFreeDelay = time.Second
+	defer func() {
+		minFreeDelay = oldMinFreeDelay
+	}()
+
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "Rollback", pluginNames...)
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log), WithRollbackDepth(1))
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if err := swapper.Rollback(); err == nil {
+		t.Fatal("Rollback should fail when there is no previous generation")
+	}
+
+	mgr1 := swapper.Current()
+	buildArgs := []string{"--", "-ldflags", "-X main.CompileTimeString=stark"}
+	preparePluginGroupImpl(t, buildArgs, "Rollback", false, "snow")
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	mgr2 := swapper.Current()
+	if mgr1.FindPlugin("arya").Refs.Load() != 2 {
+		t.Fatal(`mgr1.FindPlugin("arya").Refs.Load() != 2`)
+	}
+
+	time.Sleep(time.Millisecond * 1200)
+	if log.StringExists("invoking snow.OnFree") {
+		t.Fatal("the previous generation should be kept alive")
+	}
+
+	if err := swapper.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if swapper.Current() != mgr1 {
+		t.Fatal("swapper.Current() != mgr1")
+	}
+	var compileTimeString string
+	if err := swapper.Current().FindPlugin("snow").Lookup("CompileTimeString", &compileTimeString); err != nil {
+		t.Fatal(err)
+	} else if compileTimeString != "" {
+		t.Fatal("unexpected CompileTimeString: " + compileTimeString)
+	}
+	if err := swapper.Rollback(); err == nil {
+		t.Fatal("Rollback should fail when the history is exhausted")
+	}
+
+	time.Sleep(time.Millisecond * 1200)
+	if !log.StringExists("invoking snow.OnFree") {
+		t.Fatal("the abandoned snow should have been freed")
+	}
+	if log.StringExists("invoking arya.OnFree") {
+		t.Fatal("arya is still in use")
+	}
+	if mgr2.FindPlugin("arya").Refs.Load() != 1 {
+		t.Fatal(`mgr2.FindPlugin("arya").Refs.Load() != 1`)
+	}
+}
+
+func TestWithLiveFuncSignatures(t *testing.T) {
+	plugins := newFakeStaticPlugins("alpha")
+	plugins["alpha"].hotswapLiveFuncs = func() map[string]interface{} {
+		return map[string]interface{}{
+			"live_Greet": func(name string) string { return "hello " + name },
+		}
+	}
+	plugins["alpha"].hotswapLiveTypes = func() map[string]func() interface{} {
+		return map[string]func() interface{}{
+			"Live_Counter": func() interface{} { return &strings.Builder{} },
+		}
+	}
+
+	log := newScavenger()
+	sigs1 := map[string]interface{}{
+		"live_Greet": (func(string) string)(nil),
+	}
+	swapper1 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins), WithLiveFuncSignatures(sigs1))
+	if _, err := swapper1.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &swapper1.Current().Vault
+	if greet, err := vault.Func[func(string) string](v, "live_Greet"); err != nil {
+		t.Fatal(err)
+	} else if greet("arya") != "hello arya" {
+		t.Fatal(`greet("arya") != "hello arya"`)
+	}
+	if _, err := vault.Func[func(int) string](v, "live_Greet"); err == nil {
+		t.Fatal("vault.Func should fail when the signature does not match")
+	} else if !strings.Contains(err.Error(), "expected: func(int) string, actual: func(string) string") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := vault.Func[func(string) string](v, "live_Nobody"); err == nil {
+		t.Fatal("vault.Func should fail when the live function does not exist")
+	}
+	if _, err := vault.New[fmt.Stringer](v, "Live_Counter"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.New[error](v, "Live_Counter"); err == nil {
+		t.Fatal("vault.New should fail when the live type does not implement the interface")
+	}
+
+	sigs2 := map[string]interface{}{
+		"live_Greet": (func(string) error)(nil),
+	}
+	swapper2 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins), WithLiveFuncSignatures(sigs2))
+	if _, err := swapper2.LoadPlugins(nil); err == nil {
+		t.Fatal("LoadPlugins should fail when a live function does not match its signature")
+	} else if !strings.Contains(err.Error(), "unexpected signature of the live function live_Greet") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWithNamespacedLiveSymbols(t *testing.T) {
+	plugins := newFakeStaticPlugins("alpha", "beta", "gamma")
+	for _, name := range []string{"alpha", "beta"} {
+		name := name
+		plugins[name].hotswapLiveFuncs = func() map[string]interface{} {
+			return map[string]interface{}{
+				"live_Job": func() string { return name },
+			}
+		}
+		plugins[name].hotswapLiveTypes = func() map[string]func() interface{} {
+			return map[string]func() interface{}{
+				"Live_Job": func() interface{} { return &strings.Builder{} },
+			}
+		}
+	}
+	plugins["gamma"].hotswapLiveFuncs = func() map[string]interface{} {
+		return map[string]interface{}{
+			"live_Solo": func() string { return "gamma" },
+		}
+	}
+
+	log := newScavenger()
+	swapper1 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins))
+	if _, err := swapper1.LoadPlugins(nil); err == nil || !strings.Contains(err.Error(), "duplicate live function name") {
+		t.Fatalf("LoadPlugins should fail without the namespaced mode. err: %v", err)
+	}
+
+	sigs := map[string]interface{}{
+		"live_Job": (func() string)(nil),
+	}
+	swapper2 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins),
+		WithNamespacedLiveSymbols(), WithLiveFuncSignatures(sigs))
+	if _, err := swapper2.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	v := &swapper2.Current().Vault
+	if _, ok := v.LiveFuncs["alpha/live_Job"]; !ok {
+		t.Fatal("cannot find alpha/live_Job")
+	}
+	if _, ok := v.LiveTypes["beta/Live_Job"]; !ok {
+		t.Fatal("cannot find beta/Live_Job")
+	}
+	for _, name := range []string{"alpha", "beta"} {
+		fn, err := vault.PluginFunc[func() string](v, name, "live_Job")
+		if err != nil {
+			t.Fatal(err)
+		} else if fn() != name {
+			t.Fatalf("fn() != %q", name)
+		}
+		if _, err := vault.PluginNew[fmt.Stringer](v, name, "Live_Job"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := v.FindFunc("", "live_Job"); ok {
+		t.Fatal("an ambiguous name should not be resolved")
+	}
+	if fn, err := vault.PluginFunc[func() string](v, "alpha", "live_Solo"); err != nil {
+		t.Fatal(err)
+	} else if fn() != "gamma" {
+		t.Fatal(`fn() != "gamma"`)
+	}
+
+	sigs["live_Job"] = (func() int)(nil)
+	swapper3 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins),
+		WithNamespacedLiveSymbols(), WithLiveFuncSignatures(sigs))
+	if _, err := swapper3.LoadPlugins(nil); err == nil {
+		t.Fatal("the signatures should be checked under the namespaced mode")
+	}
+}
+
+func TestPluginManagerSwapper_OnMigrate(t *testing.T) {
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "OnMigrate", pluginNames...)
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log))
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if v := swapper.Current().FindPlugin("snow").migrated; v != 1 {
+		t.Fatalf("unexpected migrated state: %v", v)
+	}
+	if v := swapper.Current().FindPlugin("arya").migrated; v != nil {
+		t.Fatalf("unexpected migrated state: %v", v)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := swapper.Current().FindPlugin("snow").InvokeFunc("bump"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buildArgs := []string{"--", "-ldflags", "-X main.CompileTimeString=stark"}
+	preparePluginGroupImpl(t, buildArgs, "OnMigrate", false, "snow")
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	if v := swapper.Current().FindPlugin("snow").migrated; v != 4 {
+		t.Fatalf("the state changed after the load should be migrated. migrated: %v", v)
+	}
+	if !log.StringExists("invoking snow.OnSnapshot") || !log.StringExists("invoking snow.OnMigrate") {
+		t.Fatal("snow.OnSnapshot and snow.OnMigrate should have been invoked")
+	}
+}
+
+func TestWithHookTimeout(t *testing.T) {
+	plugins := newFakeStaticPlugins("alpha", "beta")
+	plugins["alpha"].fOnLoad = func(data interface{}) error {
+		return errors.New("OnLoad should not be invoked")
+	}
+	plugins["alpha"].fOnLoadContext = func(ctx context.Context, data interface{}) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return errors.New("the context has no deadline")
+		}
+		return nil
+	}
+	plugins["alpha"].InvokeFuncContext = func(ctx context.Context, name string, params ...interface{}) (interface{}, error) {
+		return "ctx:" + name, nil
+	}
+
+	log := newScavenger()
+	swapper1 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins), WithHookTimeout(time.Second))
+	if _, err := swapper1.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := swapper1.Current().FindPlugin("alpha").InvokeContext(context.Background(), "foo"); err != nil {
+		t.Fatal(err)
+	} else if v != "ctx:foo" {
+		t.Fatalf("unexpected result: %v", v)
+	}
+	if v, err := swapper1.Current().FindPlugin("beta").InvokeContext(context.Background(), "foo"); err != nil {
+		t.Fatal(err)
+	} else if v != "foo" {
+		t.Fatalf("unexpected result: %v", v)
+	}
+
+	var hookReturned atomic.Bool
+	freed := make(chan bool, 1)
+	plugins["beta"].fOnFree = func() {
+		freed <- hookReturned.Load()
+	}
+	release := make(chan struct{})
+	plugins["beta"].fOnInitContext = func(ctx context.Context, sharedVault *vault.Vault) error {
+		<-ctx.Done()
+		<-release
+		hookReturned.Store(true)
+		return nil
+	}
+	swapper2 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins), WithHookTimeout(time.Millisecond*50))
+	if _, err := swapper2.LoadPlugins(nil); err == nil {
+		t.Fatal("LoadPlugins should fail when a hook times out")
+	} else if !strings.Contains(err.Error(), "beta> OnInit timed out") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-freed:
+		t.Fatal("OnFree should not be invoked while the hook is still running")
+	default:
+	}
+	close(release)
+	select {
+	case ok := <-freed:
+		if !ok {
+			t.Fatal("OnFree should be invoked after the hook returns")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnFree should have been invoked after the hook returned")
+	}
+}
+
+func TestPluginManagerSwapper_Acquire(t *testing.T) {
+	oldMinFreeDelay := minFreeDelay
+	minFreeDelay = time.Millisecond * 300
+	defer func() {
+		minFreeDelay = oldMinFreeDelay
+	}()
+
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "Acquire", pluginNames...)
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log), WithLeaseDraining(), WithRollbackDepth(1))
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr1, release1 := swapper.Acquire()
+	if mgr1 != swapper.Current() {
+		t.Fatal("mgr1 != swapper.Current()")
+	}
+	buildArgs := []string{"--", "-ldflags", "-X main.CompileTimeString=stark"}
+	preparePluginGroupImpl(t, buildArgs, "Acquire", false, "snow")
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	mgr2, release2 := swapper.Acquire()
+	if mgr2 == mgr1 {
+		t.Fatal("mgr2 == mgr1")
+	}
+
+	if err := swapper.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	release1()
+	time.Sleep(time.Millisecond * 500)
+	if log.StringExists("invoking snow.OnFree") {
+		t.Fatal("mgr2 should not be freed while it is leased")
+	}
+	release2()
+	release2()
+	time.Sleep(time.Millisecond * 200)
+	if !log.StringExists("invoking snow.OnFree") {
+		t.Fatal("mgr2 should be freed once all its leases are released")
+	}
+	if mgr2.leases.acquire() {
+		t.Fatal("no lease should be acquired after mgr2 is drained")
+	}
+
+	plugins := newFakeStaticPlugins("alpha")
+	var freed atomic.Bool
+	plugins["alpha"].fOnFree = func() {
+		freed.Store(true)
+	}
+	swapper2 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins), WithLeaseDraining())
+	if _, err := swapper2.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	mgr3, release3 := swapper2.Acquire()
+	defer release3()
+	swapper2.scheduleFree(mgr3)
+	time.Sleep(time.Millisecond * 500)
+	if freed.Load() {
+		t.Fatal("mgr3 should not be freed before the free delay while it is leased")
+	}
+	time.Sleep(time.Millisecond * 1000)
+	if !freed.Load() || !log.StringExists("1 lease(s) still held") {
+		t.Fatal("mgr3 should be freed after the free delay")
+	}
+
+	plugins = newFakeStaticPlugins("beta")
+	var freed2 atomic.Bool
+	plugins["beta"].fOnFree = func() {
+		freed2.Store(true)
+	}
+	swapper3 := newSwapper("", WithLogger(log), WithStaticPlugins(plugins), WithLeaseDraining())
+	if _, err := swapper3.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	mgr4 := swapper3.Current()
+	swapper3.scheduleFree(mgr4)
+	time.Sleep(time.Millisecond * 100)
+	if freed2.Load() {
+		t.Fatal("mgr4 should not be freed before the minimum free delay")
+	}
+	if pm, _ := swapper3.Acquire(); pm != nil {
+		t.Fatal("Acquire should not spin on a retired PluginManager")
+	}
+	time.Sleep(time.Millisecond * 500)
+	if !freed2.Load() {
+		t.Fatal("mgr4 should be freed after the minimum free delay")
+	}
+}
+
+func TestWithSignatureVerification(t *testing.T) {
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "Signature", pluginNames...)
+	files := completePluginPaths(outputDir, pluginNames...)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "hotswap.key")
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(priv.Seed())), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(files ...string) {
+		t.Helper()
+		args := append([]string{"sign", "--key", keyFile}, files...)
+		if output, err := exec.Command("cli/hotswap/hotswap", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%v\n%s", err, output)
+		}
+	}
+	for _, f := range files {
+		_ = os.Remove(hutils.SignatureFile(f))
+	}
+	sign(files[0])
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log), WithSignatureVerification(pub))
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err == nil || !strings.Contains(err.Error(), "snow.so is missing") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, anotherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hutils.SignatureFile(files[1]), hutils.SignPlugin(anotherKey, "snow", "", data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swapper.LoadPlugins(log); !errors.Is(err, hutils.ErrInvalidSignature) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(hutils.SignatureFile(files[1]), hutils.SignPlugin(priv, "arya", "", data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swapper.LoadPlugins(log); !errors.Is(err, hutils.ErrInvalidSignature) {
+		t.Fatal("the signature of another plugin name should be rejected")
+	}
+
+	sign(files[1])
+	sigData, err := os.ReadFile(hutils.SignatureFile(files[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []ed25519.PublicKey{pub}
+	if err := hutils.VerifyPlugin(keys, "snow", "1.0.0", sha256.Sum256(data), sigData); !errors.Is(err, hutils.ErrInvalidSignature) {
+		t.Fatal("the signature of another plugin version should be rejected")
+	}
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	snow := swapper.Current().FindPlugin("snow")
+	if snow.FileSha256 != sha256.Sum256(data) {
+		t.Fatal("unexpected FileSha256")
+	}
+	privateDir := filepath.Dir(snow.actualFile)
+	if filepath.Dir(privateDir) != swapper.tempRoot() {
+		t.Fatal("the verified plugins should be copied into the temp directory: " + privateDir)
+	}
+	if pid, ok := tempDirPid(filepath.Base(privateDir)); !ok || pid != os.Getpid() {
+		t.Fatal("the private directory should be recognized by cleanTempDirs: " + privateDir)
+	}
+	if fi, err := os.Stat(privateDir); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0700 {
+		t.Fatalf("unexpected permissions of %s: %v", privateDir, fi.Mode().Perm())
+	}
+
+	if err := verifyCopy(snow.actualFile, snow.FileSha256); err != nil {
+		t.Fatal(err)
+	}
+	tampered := filepath.Join(t.TempDir(), "snow.so")
+	if err := os.WriteFile(tampered, []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCopy(tampered, snow.FileSha256); err == nil {
+		t.Fatal("verifyCopy should detect the tampered copy")
+	}
+}
+
+func TestWithManifestCheck(t *testing.T) {
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "Manifest", pluginNames...)
+
+	log := newScavenger()
+	swapper1 := newSwapper(outputDir, WithLogger(log), WithManifestCheck())
+	prepareEnv(t, "")
+	if _, err := swapper1.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	m := swapper1.Current().FindPlugin("arya").Manifest
+	if m == nil {
+		t.Fatal("arya should have a manifest")
+	}
+	if m.Plugin != "arya" || m.GoVersion != runtime.Version() || len(m.Commit) != 40 {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	if !reflect.DeepEqual(m.LiveTypes, []string{"Live_AryaKill"}) {
+		t.Fatalf("unexpected live types: %v", m.LiveTypes)
+	}
+
+	tamperedDir := filepath.Join(filepath.Dir(outputDir), "Manifest-tampered")
+	if err := os.MkdirAll(tamperedDir, 0744); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(outputDir, "snow.so"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"GoVersion":"go1`), []byte(`"GoVersion":"go0`), 1)
+	if err := os.WriteFile(filepath.Join(tamperedDir, "snow.so"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	swapper2 := newSwapper(tamperedDir, WithLogger(log), WithManifestCheck())
+	if _, err := swapper2.LoadPlugins(log); err == nil || !strings.Contains(err.Error(), "the plugin was built with go0") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m2 := &Manifest{
+		GoVersion: runtime.Version(),
+		Deps: map[string]string{
+			"go.uber.org/atomic": "v1.9.0",
+			"go.uber.org/zap":    "v1.23.0",
+		},
+	}
+	hostDeps := map[string]string{
+		"go.uber.org/atomic": "v1.10.0",
+		"go.uber.org/zap":    "v1.23.0",
+	}
+	if err := checkManifest(m2, hostDeps); err == nil || !strings.Contains(err.Error(), "go.uber.org/atomic v1.9.0 (host: v1.10.0)") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delete(m2.Deps, "go.uber.org/atomic")
+	if err := checkManifest(m2, hostDeps); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithTempCleanup(t *testing.T) {
+	oldMinFreeDelay := minFreeDelay
+	minFreeDelay = time.Second
+	defer func() {
+		minFreeDelay = oldMinFreeDelay
+	}()
+
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "TempCleanup", pluginNames...)
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	tempDir := t.TempDir()
+	now := time.Now().Format(hutils.CompactDateTimeFormat)
+	staleDir := filepath.Join(tempDir, fmt.Sprintf("%s-%d", now, cmd.Process.Pid))
+	stalePrivateDir := filepath.Join(tempDir, fmt.Sprintf("%s-%d-123456", now, cmd.Process.Pid))
+	otherDir := filepath.Join(tempDir, "other")
+	for _, dir := range []string{staleDir, stalePrivateDir, otherDir} {
+		if err := os.MkdirAll(dir, 0744); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log), WithTempDir(tempDir), WithTempCleanup())
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(staleDir); !os.IsNotExist(err) {
+		t.Fatal("the directory left by a dead process should be removed")
+	}
+	if _, err := os.Stat(stalePrivateDir); !os.IsNotExist(err) {
+		t.Fatal("the private directory left by a dead process should be removed")
+	}
+	if _, err := os.Stat(otherDir); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr1 := swapper.Current()
+	aryaFile := mgr1.FindPlugin("arya").actualFile
+	snowFile := mgr1.FindPlugin("snow").actualFile
+	if filepath.Dir(filepath.Dir(snowFile)) != tempDir {
+		t.Fatalf("unexpected actual file: %s", snowFile)
+	}
+	buildArgs := []string{"--", "-ldflags", "-X main.CompileTimeString=stark"}
+	preparePluginGroupImpl(t, buildArgs, "TempCleanup", false, "snow")
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 1500)
+	if _, err := os.Stat(snowFile); !os.IsNotExist(err) {
+		t.Fatal("the copy of the freed snow should be removed")
+	}
+	if _, err := os.Stat(aryaFile); err != nil {
+		t.Fatal("the copy of arya is still in use")
+	}
+	if _, err := os.Stat(swapper.Current().FindPlugin("snow").actualFile); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/edwingeng/hotswap/cli/hotswap/trial/export/importall"
 	"github.com/edwingeng/hotswap/internal/hutils"
@@ -77,6 +79,16 @@ func completePluginPaths(outputDir string, names ...string) []string {
 	return a
 }
 
+func newFakeStaticPlugins(names ...string) map[string]*StaticPlugin {
+	m := make(map[string]*StaticPlugin)
+	for _, name := range names {
+		m[name] = NewStaticPlugin(name, WithInvokeFunc(func(name string, params ...interface{}) (interface{}, error) {
+			return name, nil
+		}))
+	}
+	return m
+}
+
 func invariants(t *testing.T, mgr *PluginManager) {
 	t.Helper()
 	if len(mgr.ordered) != len(mgr.pluginMap) {
@@ -1064,3 +1076,126 @@ func TestPluginManager_panicTrigger2(t *testing.T) {
 
 	panics_checkInvokeEveryOnFree(t, log, false, pluginNames...)
 }
+
+func TestPluginManager_InvokeEachConcurrently(t *testing.T) {
+	plugins := newFakeStaticPlugins("alpha", "beta", "gamma")
+	var mu sync.Mutex
+	var finished []string
+	for name, sp := range plugins {
+		name := name
+		sp.InvokeFunc = func(fn string, params ...interface{}) (interface{}, error) {
+			switch fn {
+			case "sleep":
+				if name != "gamma" {
+					time.Sleep(time.Millisecond * 200)
+				}
+				mu.Lock()
+				finished = append(finished, name)
+				mu.Unlock()
+			case "fail":
+				if name == "beta" {
+					return nil, errors.New("beta failed")
+				}
+				if name == "gamma" {
+					panic("gamma panicked")
+				}
+			}
+			return name, nil
+		}
+	}
+
+	mgr := newPluginManager(newScavenger(), nilNewer)
+	if err := mgr.loadStaticPlugins(plugins, nil); err != nil {
+		t.Fatal(err)
+	}
+	mgr.FindPlugin("gamma").Deps = []string{"alpha", "beta"}
+	mgr.ordered = []*Plugin{mgr.FindPlugin("alpha"), mgr.FindPlugin("beta"), mgr.FindPlugin("gamma")}
+
+	start := time.Now()
+	results := mgr.InvokeEachConcurrently(4, "sleep")
+	if d := time.Since(start); d > time.Millisecond*350 {
+		t.Fatalf("alpha and beta should run in parallel. elapsed: %v", d)
+	}
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(finished) != 3 || finished[2] != "gamma" {
+		t.Fatalf("gamma should run after its dependencies: %v", finished)
+	}
+	for i, p := range mgr.Plugins() {
+		if results[i].Plugin != p.Name || results[i].Value != p.Name {
+			t.Fatalf("unexpected result: %+v", results[i])
+		}
+	}
+
+	results = mgr.InvokeEachWithResults("fail")
+	for i, r := range results {
+		if r.Plugin != mgr.ordered[i].Name || (r.Err != nil) != (r.Plugin != "alpha") {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+	}
+	if err := results.Err(); err == nil || !strings.Contains(err.Error(), "2 plugin(s) failed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results = mgr.InvokeEachBackwardWithResults("fail")
+	if results[0].Plugin != "gamma" || !strings.Contains(results[0].Err.Error(), "gamma panicked") {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
+
+func TestPluginManager_rpc(t *testing.T) {
+	pluginNames := []string{"arya", "bran"}
+	outputDir := preparePluginGroup(t, nil, "rpc", pluginNames...)
+	files := completePluginPaths(outputDir, pluginNames...)
+
+	log := newScavenger()
+	mgr := newPluginManager(log, nilNewer)
+	prepareEnv(t, "")
+	if err := mgr.loadPlugins(files, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bran := mgr.FindPlugin("bran")
+	if v, err := bran.InvokeFunc("Greet", "Arya"); err != nil {
+		t.Fatal(err)
+	} else if v != "Hodor, Arya" {
+		t.Fatalf("unexpected result: %v", v)
+	}
+	if _, err := bran.InvokeFunc("Greet", ""); err == nil || err.Error() != "who are you?" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, err := bran.InvokeFunc("Sum", 1, 2, 3); err != nil {
+		t.Fatal(err)
+	} else if v != 6 {
+		t.Fatalf("unexpected result: %v", v)
+	}
+	if v, err := bran.InvokeFunc("Warg"); err != nil || v != nil {
+		t.Fatalf("unexpected result: %v, %v", v, err)
+	}
+	if _, err := bran.InvokeFunc("Greet", 1); err == nil || !strings.Contains(err.Error(), "int is not assignable to string") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := bran.InvokeFunc("Greet"); err == nil {
+		t.Fatal("InvokeFunc should fail when the number of arguments is wrong")
+	}
+	if _, err := bran.InvokeFunc("dream"); !errors.Is(err, ErrUnknownFunc) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	greet, err := Method[func(string) (string, error)](bran, "Greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := greet("Jon"); err != nil || v != "Hodor, Jon" {
+		t.Fatalf("unexpected result: %v, %v", v, err)
+	}
+	if _, err := Method[func(int) string](bran, "Greet"); err == nil {
+		t.Fatal("Method should fail when the signature does not match")
+	}
+	if _, err := Method[func()](bran, "Fly"); !errors.Is(err, ErrUnknownFunc) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Method[func()](mgr.FindPlugin("arya"), "Warg"); !errors.Is(err, ErrUnknownFunc) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
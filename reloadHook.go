@@ -0,0 +1,66 @@
+package hotswap
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// ReloadHook is a two-phase hook of reloading, rolling back included.
+//
+// Prepare is called after the ReloadCallback, right before the new PluginManager becomes
+// visible. Returning an error vetoes the swap. Commit is called once the new PluginManager is
+// returned by Current. Abort is called with the discarded PluginManager whenever a reload fails,
+// including the failures before Prepare, after OnFree of its plugins has been invoked.
+// A vetoed rollback discards nothing, so Abort is not called. EventRollbackVetoed is emitted
+// instead.
+type ReloadHook interface {
+	Prepare(newManager, oldManager *PluginManager) error
+	Commit(newManager, oldManager *PluginManager)
+	Abort(newManager *PluginManager, err error)
+}
+
+func (sw *PluginManagerSwapper) prepareReload(newManager, oldManager *PluginManager) error {
+	for _, hook := range sw.opts.reloadHooks {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("<hotswap> panic: %+v\n%s", r, debug.Stack())
+				}
+			}()
+			return hook.Prepare(newManager, oldManager)
+		}()
+		if err != nil {
+			return fmt.Errorf("the swap is vetoed. err: %w", err)
+		}
+	}
+	return nil
+}
+
+func (sw *PluginManagerSwapper) commitReload(newManager, oldManager *PluginManager) {
+	for _, hook := range sw.opts.reloadHooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					sw.Errorf("<hotswap> panic: %+v\n%s", r, debug.Stack())
+				}
+			}()
+			hook.Commit(newManager, oldManager)
+		}()
+	}
+}
+
+func (sw *PluginManagerSwapper) abortReload(newManager *PluginManager, start time.Time, failed EventKind, err error) {
+	sw.emit(EventReloadAborted, "", start, err)
+	sw.recordAbortedReload(start, failed, err)
+	for _, hook := range sw.opts.reloadHooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					sw.Errorf("<hotswap> panic: %+v\n%s", r, debug.Stack())
+				}
+			}()
+			hook.Abort(newManager, err)
+		}()
+	}
+}
@@ -0,0 +1,76 @@
+package hotswap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type recordingHook struct {
+	sw    *PluginManagerSwapper
+	veto  error
+	calls []string
+}
+
+func (h *recordingHook) Prepare(newManager, oldManager *PluginManager) error {
+	if h.sw.Current() == newManager {
+		h.calls = append(h.calls, "prepare:visible")
+	} else {
+		h.calls = append(h.calls, "prepare")
+	}
+	return h.veto
+}
+
+func (h *recordingHook) Commit(newManager, oldManager *PluginManager) {
+	if h.sw.Current() == newManager {
+		h.calls = append(h.calls, "commit")
+	} else {
+		h.calls = append(h.calls, "commit:invisible")
+	}
+}
+
+func (h *recordingHook) Abort(newManager *PluginManager, err error) {
+	h.calls = append(h.calls, "abort:"+err.Error())
+}
+
+func TestWithReloadHook(t *testing.T) {
+	var freed int
+	plugins := newFakeStaticPlugins("alpha")
+	plugins["alpha"].fOnFree = func() {
+		freed++
+	}
+
+	hook := &recordingHook{veto: errors.New("not now")}
+	log := newScavenger()
+	swapper := newSwapper("", WithLogger(log), WithStaticPlugins(plugins), WithReloadHook(hook))
+	hook.sw = swapper
+	if _, err := swapper.LoadPlugins(nil); err == nil || !strings.Contains(err.Error(), "the swap is vetoed. err: not now") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if swapper.Current() != nil || freed != 1 {
+		t.Fatal("the vetoed PluginManager should be discarded")
+	}
+	if strings.Join(hook.calls, ",") != "prepare,abort:the swap is vetoed. err: not now" {
+		t.Fatalf("unexpected calls: %v", hook.calls)
+	}
+
+	hook.veto, hook.calls = nil, nil
+	if _, err := swapper.LoadPlugins(nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(hook.calls, ",") != "prepare,commit" {
+		t.Fatalf("unexpected calls: %v", hook.calls)
+	}
+
+	hook.calls = nil
+	plugins["alpha"].fOnLoadContext = nil
+	plugins["alpha"].fOnLoad = func(data interface{}) error {
+		return errors.New("broken")
+	}
+	if _, err := swapper.LoadPlugins(nil); err == nil {
+		t.Fatal("LoadPlugins should fail")
+	}
+	if len(hook.calls) != 1 || !strings.HasPrefix(hook.calls[0], "abort:") || !strings.Contains(hook.calls[0], "broken") {
+		t.Fatalf("unexpected calls: %v", hook.calls)
+	}
+}
@@ -0,0 +1,108 @@
+package hotswap
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+type PluginStatus string
+
+const (
+	PluginStatusOK            PluginStatus = "ok"
+	PluginStatusUnchanged     PluginStatus = "unchanged"
+	PluginStatusNotReloadable PluginStatus = "not reloadable"
+)
+
+type PluginTimings struct {
+	Export time.Duration
+	Import time.Duration
+	OnLoad time.Duration
+	OnInit time.Duration
+}
+
+type PluginReport struct {
+	Name       string
+	File       string
+	Version    string `json:",omitempty"`
+	OldVersion string `json:",omitempty"`
+	OldSha1    string `json:",omitempty"`
+	NewSha1    string `json:",omitempty"`
+	Status     PluginStatus
+	Deps       []string
+	Timings    PluginTimings
+	Generation int64
+}
+
+// ReloadReport is a JSON-serializable description of a reload. Generation of a
+// PluginReport is the generation in which the plugin was actually loaded.
+type ReloadReport struct {
+	Generation int64
+	When       time.Time
+	Duration   time.Duration
+	Plugins    []*PluginReport
+	window     *rollbackWindow
+}
+
+// Details returns the status of every plugin keyed by its file, or by name@version if the
+// plugin is versioned.
+func (r *ReloadReport) Details() Details {
+	d := make(Details)
+	for _, pr := range r.Plugins {
+		if pr.Version != "" {
+			d[pr.Name+"@"+pr.Version] = string(pr.Status)
+		} else if pr.File != "" {
+			d[pr.File] = string(pr.Status)
+		} else {
+			d[pr.Name] = string(pr.Status)
+		}
+	}
+	return d
+}
+
+func sha1String(p *Plugin) string {
+	if p.File == "" {
+		return ""
+	}
+	return hex.EncodeToString(p.FileSha1[:])
+}
+
+func newReloadReport(newManager, oldManager *PluginManager, generation int64, start time.Time) *ReloadReport {
+	r := &ReloadReport{
+		Generation: generation,
+		When:       start,
+		Duration:   time.Since(start),
+	}
+	for _, p := range newManager.Plugins() {
+		if !p.unchanged {
+			p.generation = generation
+		}
+		pr := &PluginReport{
+			Name:       p.Name,
+			File:       p.File,
+			Version:    p.Version,
+			NewSha1:    sha1String(p),
+			Status:     PluginStatusOK,
+			Deps:       p.Deps,
+			Timings:    p.timings,
+			Generation: p.generation,
+		}
+		switch p.Note {
+		case "", "ok":
+		default:
+			pr.Status = PluginStatus(p.Note)
+		}
+		if oldManager != nil {
+			if oldP := oldManager.FindPlugin(p.Name); oldP != nil {
+				pr.OldSha1 = sha1String(oldP)
+				pr.OldVersion = oldP.Version
+			}
+		}
+		r.Plugins = append(r.Plugins, pr)
+	}
+	return r
+}
+
+// Report returns the ReloadReport of the reload which created the PluginManager.
+func (pm *PluginManager) Report() *ReloadReport {
+	return pm.report
+}
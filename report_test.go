@@ -0,0 +1,52 @@
+package hotswap
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReloadReport(t *testing.T) {
+	log := newScavenger()
+	swapper := newSwapper("", WithLogger(log), WithStaticPlugins(newFakeStaticPlugins("alpha", "beta")))
+	details, err := swapper.LoadPlugins(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := swapper.Current().Report()
+	if r == nil {
+		t.Fatal("r == nil")
+	}
+	if r.Generation != 1 {
+		t.Fatal("r.Generation != 1")
+	}
+	if len(r.Plugins) != 2 {
+		t.Fatal("len(r.Plugins) != 2")
+	}
+	for _, pr := range r.Plugins {
+		if pr.Status != PluginStatusOK {
+			t.Fatalf("unexpected status. plugin: %s, status: %s", pr.Name, pr.Status)
+		}
+		if pr.Generation != 1 {
+			t.Fatalf("unexpected generation. plugin: %s, generation: %d", pr.Name, pr.Generation)
+		}
+		if pr.NewSha1 != "" || pr.OldSha1 != "" {
+			t.Fatal("static plugins have no file")
+		}
+	}
+	if details.String() != r.Details().String() {
+		t.Fatal("details.String() != r.Details().String()")
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r2 ReloadReport
+	if err := json.Unmarshal(data, &r2); err != nil {
+		t.Fatal(err)
+	}
+	if r2.Generation != r.Generation || len(r2.Plugins) != len(r.Plugins) || r2.Plugins[1].Name != r.Plugins[1].Name {
+		t.Fatal("the report does not survive a JSON round trip")
+	}
+}
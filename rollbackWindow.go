@@ -0,0 +1,188 @@
+package hotswap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// RollbackProbe is called periodically during the rollback window. Returning an error rolls
+// back the reload.
+type RollbackProbe func(newManager *PluginManager) error
+
+// RollbackError is returned by WaitRollbackWindow when the new plugins failed the rollback
+// window. Err is the reason. RollbackErr is nil if the previous generation was restored, or
+// the reason why it could not be, in which case the new plugins are still live.
+type RollbackError struct {
+	Err         error
+	RollbackErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("the new plugins failed the rollback window, but failed to roll back. err: %v, rollback err: %v",
+			e.Err, e.RollbackErr)
+	}
+	return "the new plugins are rolled back. err: " + e.Err.Error()
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// ErrRollbackWindowEnded is returned by WaitRollbackWindow when the rollback window was ended
+// early by Rollback or by another reload, before the new plugins passed or failed it.
+var ErrRollbackWindowEnded = errors.New("the rollback window was ended early")
+
+type rollbackWindow struct {
+	newManager *PluginManager
+	stop       chan struct{}
+	done       chan struct{}
+	err        error
+}
+
+func newRollbackWindow(newManager *PluginManager) *rollbackWindow {
+	return &rollbackWindow{
+		newManager: newManager,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// startRollbackWindow starts watching the new PluginManager of w in the background. Must be
+// called with sw.mu held.
+func (sw *PluginManagerSwapper) startRollbackWindow(w *rollbackWindow) {
+	sw.window = w
+	sw.lastWindow = w
+	go sw.runRollbackWindow(w)
+}
+
+// endRollbackWindow ends the running rollback window, if any, because its PluginManager is
+// being replaced. Must be called with sw.mu held.
+func (sw *PluginManagerSwapper) endRollbackWindow() {
+	if sw.window != nil {
+		close(sw.window.stop)
+		sw.window = nil
+	}
+}
+
+func (sw *PluginManagerSwapper) runRollbackWindow(w *rollbackWindow) {
+	defer close(w.done)
+	err := sw.watchRollbackWindow(w)
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.window != w {
+		w.err = ErrRollbackWindowEnded
+		return
+	}
+	sw.window = nil
+	if err != nil {
+		w.err = sw.autoRollback(err)
+	}
+	sw.trimHistory(0)
+}
+
+// watchRollbackWindow watches the new PluginManager until the rollback window ends, and
+// returns the first problem found.
+func (sw *PluginManagerSwapper) watchRollbackWindow(w *rollbackWindow) error {
+	window := sw.opts.rollbackWindow
+	interval := sw.opts.rollbackInterval
+	if interval <= 0 || interval > window {
+		interval = window
+	}
+
+	baseline := countPanics(w.newManager)
+	deadline := time.Now().Add(window)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return nil
+		case <-ticker.C:
+		}
+		if err := sw.probe(w.newManager, baseline); err != nil {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return nil
+		}
+	}
+}
+
+func (sw *PluginManagerSwapper) probe(newManager *PluginManager, baseline int64) error {
+	if n := countPanics(newManager) - baseline; n > 0 {
+		return fmt.Errorf("%d panic(s) occurred", n)
+	}
+	if err := sw.checkHealth(newManager); err != nil {
+		return err
+	}
+	if sw.opts.rollbackProbe == nil {
+		return nil
+	}
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("<hotswap> panic: %+v\n%s", r, debug.Stack())
+			}
+		}()
+		return sw.opts.rollbackProbe(newManager)
+	}()
+	if err != nil {
+		return fmt.Errorf("the probe failed. err: %w", err)
+	}
+	return nil
+}
+
+func countPanics(pm *PluginManager) int64 {
+	var n int64
+	for _, p := range pm.ordered {
+		n += p.Panics()
+	}
+	return n
+}
+
+// autoRollback restores the previous PluginManager after the current one failed the rollback
+// window. Must be called with sw.mu held.
+func (sw *PluginManagerSwapper) autoRollback(cause error) error {
+	start := time.Now()
+	sw.Warnf("<hotswap> the new plugins failed the rollback window. err: %v", cause)
+	if err := sw.rollbackLocked(); err != nil {
+		sw.Errorf("<hotswap> failed to roll back. err: %v", err)
+		return &RollbackError{Err: cause, RollbackErr: err}
+	}
+	sw.emit(EventRolledBack, "", start, cause)
+	return &RollbackError{Err: cause}
+}
+
+// WaitRollbackWindow waits until the rollback window of the latest reload ends. See
+// ReloadReport.WaitRollbackWindow for the result.
+func (sw *PluginManagerSwapper) WaitRollbackWindow(ctx context.Context) error {
+	sw.mu.Lock()
+	w := sw.lastWindow
+	sw.mu.Unlock()
+	return w.wait(ctx)
+}
+
+// WaitRollbackWindow waits until the rollback window of the reload described by r ends. It
+// returns nil if the new plugins passed the window or if there is no window, a *RollbackError
+// if they failed it, or ErrRollbackWindowEnded if the window was ended early by Rollback or by
+// another reload.
+func (r *ReloadReport) WaitRollbackWindow(ctx context.Context) error {
+	return r.window.wait(ctx)
+}
+
+func (w *rollbackWindow) wait(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,166 @@
+package hotswap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type vetoingHook struct {
+	target  *PluginManager
+	aborted int
+}
+
+func (h *vetoingHook) Prepare(newManager, oldManager *PluginManager) error {
+	if h.target != nil && newManager == h.target {
+		return errors.New("vetoed")
+	}
+	return nil
+}
+
+func (h *vetoingHook) Commit(newManager, oldManager *PluginManager) {}
+
+func (h *vetoingHook) Abort(newManager *PluginManager, err error) {
+	h.aborted++
+}
+
+func TestWithRollbackWindow(t *testing.T) {
+	outputDir := preparePluginGroup(t, nil, "RollbackWindow", "arya")
+
+	var probe RollbackProbe
+	hook := &vetoingHook{}
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log), WithReloadHook(hook),
+		WithRollbackWindow(time.Millisecond*50, time.Millisecond*10),
+		WithRollbackProbe(func(newManager *PluginManager) error {
+			return probe(newManager)
+		}))
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	mgr1 := swapper.Current()
+
+	var rolledBack []error
+	var vetoed, aborted int
+	unsubscribe := swapper.Subscribe(func(ev Event) {
+		switch ev.Kind {
+		case EventRolledBack:
+			rolledBack = append(rolledBack, ev.Err)
+		case EventRollbackVetoed:
+			vetoed++
+		case EventReloadAborted:
+			aborted++
+		}
+	})
+	defer unsubscribe()
+
+	probe = func(*PluginManager) error {
+		return errors.New("degraded")
+	}
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	err := swapper.WaitRollbackWindow(context.Background())
+	var rbErr *RollbackError
+	if !errors.As(err, &rbErr) || rbErr.RollbackErr != nil ||
+		!strings.Contains(rbErr.Error(), "the probe failed. err: degraded") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if swapper.Current() != mgr1 || len(rolledBack) != 1 {
+		t.Fatal("the reload should be rolled back")
+	}
+
+	var invoked bool
+	probe = func(newManager *PluginManager) error {
+		if !invoked {
+			invoked = true
+			newManager.InvokeEach("polish")
+			func() {
+				defer func() {
+					_ = recover()
+				}()
+				_, _ = newManager.FindPlugin("arya").InvokeFunc("polish")
+			}()
+		}
+		return nil
+	}
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	if err := swapper.WaitRollbackWindow(context.Background()); !errors.As(err, &rbErr) ||
+		!strings.Contains(err.Error(), "2 panic(s) occurred") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if swapper.Current() != mgr1 || len(rolledBack) != 2 {
+		t.Fatal("the reload should be rolled back")
+	}
+
+	swapper.opts.rollbackWindow = time.Minute
+	probe = func(*PluginManager) error {
+		return nil
+	}
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	if swapper.Current() == mgr1 {
+		t.Fatal("the reload should not wait for the rollback window")
+	}
+	report := swapper.Current().Report()
+	if err := swapper.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := report.WaitRollbackWindow(ctx); !errors.Is(err, ErrRollbackWindowEnded) {
+		t.Fatalf("the rollback window should be ended by Rollback. err: %v", err)
+	}
+	if err := swapper.WaitRollbackWindow(ctx); !errors.Is(err, ErrRollbackWindowEnded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if swapper.Current() != mgr1 || len(rolledBack) != 2 {
+		t.Fatal("the manual rollback should restore the previous generation")
+	}
+	swapper.opts.rollbackWindow = time.Millisecond * 50
+
+	var probed int
+	probe = func(*PluginManager) error {
+		probed++
+		return nil
+	}
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	if err := swapper.Current().Report().WaitRollbackWindow(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if swapper.Current() == mgr1 || len(rolledBack) != 2 || probed < 5 {
+		t.Fatalf("the reload should be committed. probed: %d", probed)
+	}
+	if err := swapper.Rollback(); err == nil {
+		t.Fatal("the previous generation should be freed after the rollback window")
+	}
+
+	mgr2 := swapper.Current()
+	hook.target = mgr2
+	probe = func(*PluginManager) error {
+		return errors.New("degraded")
+	}
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	err = swapper.WaitRollbackWindow(context.Background())
+	if !errors.As(err, &rbErr) || rbErr.RollbackErr == nil || !errors.Is(err, rbErr.Err) ||
+		!strings.Contains(err.Error(), "vetoed") || !strings.Contains(err.Error(), "degraded") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cur := swapper.Current(); cur == mgr2 || len(rolledBack) != 2 {
+		t.Fatal("the new plugins should stay live when the rollback fails")
+	}
+	if vetoed != 1 || aborted != 0 || hook.aborted != 0 {
+		t.Fatalf("a vetoed rollback should not abort anything. vetoed: %d, aborted: %d, hook.aborted: %d",
+			vetoed, aborted, hook.aborted)
+	}
+}
@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrUnknownFunc is returned when a plugin has no method with the requested name.
+var ErrUnknownFunc = errors.New("unknown function")
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Dispatch calls methods[name] with params. It is called by the InvokeFunc generated by
+// hotswap for the type tagged with //hotswap:rpc. A method may return nothing, a value, an
+// error, or a value and an error.
+func Dispatch(methods map[string]interface{}, name string, params ...interface{}) (_ interface{}, err error) {
+	fn, ok := methods[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFunc, name)
+	}
+
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if err := CheckResults(ft); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	numIn := ft.NumIn()
+	if ft.IsVariadic() {
+		if len(params) < numIn-1 {
+			return nil, fmt.Errorf("%s expects at least %d arguments, got %d", name, numIn-1, len(params))
+		}
+	} else if len(params) != numIn {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, numIn, len(params))
+	}
+
+	in := make([]reflect.Value, len(params))
+	for i, param := range params {
+		var t reflect.Type
+		if ft.IsVariadic() && i >= numIn-1 {
+			t = ft.In(numIn - 1).Elem()
+		} else {
+			t = ft.In(i)
+		}
+		if in[i], err = convertParam(param, t); err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %w", i, name, err)
+		}
+	}
+
+	out := fv.Call(in)
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		if ft.Out(0) == errorType {
+			return nil, toError(out[0])
+		}
+		return out[0].Interface(), nil
+	default:
+		return out[0].Interface(), toError(out[1])
+	}
+}
+
+// CheckResults reports whether a function of type ft can be dispatched, that is, whether it
+// returns nothing, a value, an error, or a value and an error.
+func CheckResults(ft reflect.Type) error {
+	switch ft.NumOut() {
+	case 0, 1:
+		return nil
+	case 2:
+		if ft.Out(1) != errorType {
+			return errors.New("the second result must be an error")
+		}
+		return nil
+	default:
+		return errors.New("too many results")
+	}
+}
+
+func convertParam(param interface{}, t reflect.Type) (reflect.Value, error) {
+	if param == nil {
+		switch t.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return reflect.Zero(t), nil
+		default:
+			return reflect.Value{}, fmt.Errorf("nil is not assignable to %s", t)
+		}
+	}
+	v := reflect.ValueOf(param)
+	if !v.Type().AssignableTo(t) {
+		return reflect.Value{}, fmt.Errorf("%s is not assignable to %s", v.Type(), t)
+	}
+	return v, nil
+}
+
+func toError(v reflect.Value) error {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Interface().(error)
+}
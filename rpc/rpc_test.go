@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDispatch(t *testing.T) {
+	var called int
+	methods := map[string]interface{}{
+		"Greet": func(name string) (string, error) {
+			called++
+			return "hello " + name, nil
+		},
+		"Fail": func() error {
+			called++
+			return errors.New("failed")
+		},
+		"Pair": func() (int, int) {
+			called++
+			return 1, 2
+		},
+		"Triple": func() (int, int, error) {
+			called++
+			return 1, 2, nil
+		},
+	}
+
+	if v, err := Dispatch(methods, "Greet", "snow"); err != nil || v != "hello snow" {
+		t.Fatalf("unexpected result: %v, %v", v, err)
+	}
+	if _, err := Dispatch(methods, "Fail"); err == nil || err.Error() != "failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Dispatch(methods, "Greet", 1); err == nil {
+		t.Fatal("Dispatch should reject an argument of a wrong type")
+	}
+	if _, err := Dispatch(methods, "Unknown"); !errors.Is(err, ErrUnknownFunc) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if called != 2 {
+		t.Fatalf("unexpected number of calls: %d", called)
+	}
+
+	for _, name := range []string{"Pair", "Triple"} {
+		if _, err := Dispatch(methods, name); err == nil {
+			t.Fatalf("%s should be rejected", name)
+		}
+	}
+	if called != 2 {
+		t.Fatal("a method with unsupported results should not be called")
+	}
+}
@@ -0,0 +1,297 @@
+package hotswap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/edwingeng/hotswap/internal/hutils"
+)
+
+// PluginEntry is a plugin file found by a PluginSource. Path identifies the file in Details
+// and log messages, and is passed back to PluginSource.ReadFile. A source may list several
+// builds of a plugin with different versions, and the VersionPolicy selects one of them.
+// Current marks the build the source considers live.
+type PluginEntry struct {
+	Name    string
+	Path    string
+	Version string `json:",omitempty"`
+	Current bool   `json:",omitempty"`
+}
+
+// PluginSource provides the plugin files to load. ReadFile is also called with
+// the path of the detached signature, i.e. Path + ".sig", under WithSignatureVerification.
+// A source may also implement Stat(path string) (fs.FileInfo, error) so that Watch can
+// detect changes to the content of a file whose path stays the same.
+type PluginSource interface {
+	List() ([]PluginEntry, error)
+	ReadFile(path string) ([]byte, error)
+}
+
+type statSource interface {
+	Stat(path string) (fs.FileInfo, error)
+}
+
+type dirSource struct {
+	dir string
+}
+
+// DirSource returns a PluginSource listing the .so files in dir. It is the default source.
+func DirSource(dir string) PluginSource {
+	return dirSource{dir: dir}
+}
+
+func (ds dirSource) List() ([]PluginEntry, error) {
+	var absDir string
+	if err := hutils.FindDirectory(ds.dir, "pluginDir"); err != nil {
+		return nil, err
+	} else if absDir, err = filepath.Abs(ds.dir); err != nil {
+		return nil, err
+	}
+
+	a, err := ioutil.ReadDir(absDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []PluginEntry
+	for _, fi := range a {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), hutils.FileNameExt) {
+			continue
+		}
+		entries = append(entries, PluginEntry{
+			Name: pluginName(fi.Name()),
+			Path: filepath.Join(absDir, fi.Name()),
+		})
+	}
+	return entries, nil
+}
+
+func (ds dirSource) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func (ds dirSource) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+type fsSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+// FSSource returns a PluginSource listing the .so files in the directory dir of fsys.
+func FSSource(fsys fs.FS, dir string) PluginSource {
+	return fsSource{fsys: fsys, dir: dir}
+}
+
+func (fss fsSource) List() ([]PluginEntry, error) {
+	a, err := fs.ReadDir(fss.fsys, fss.dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []PluginEntry
+	for _, de := range a {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), hutils.FileNameExt) {
+			continue
+		}
+		entries = append(entries, PluginEntry{
+			Name: pluginName(de.Name()),
+			Path: path.Join(fss.dir, de.Name()),
+		})
+	}
+	return entries, nil
+}
+
+func (fss fsSource) ReadFile(path string) ([]byte, error) {
+	return fs.ReadFile(fss.fsys, path)
+}
+
+func (fss fsSource) Stat(path string) (fs.FileInfo, error) {
+	return fs.Stat(fss.fsys, path)
+}
+
+const (
+	// CurrentFileName is the name of the file holding the current version of a plugin
+	// in the layout of VersionedSource.
+	CurrentFileName = "current"
+)
+
+type versionedSource struct {
+	dir string
+}
+
+// VersionedSource returns a PluginSource reading the layout <dir>/<name>/<version>.so.
+// The file <dir>/<name>/current holds the current version, which is loaded under the default
+// VersionPolicy. Write a new version first, then replace the current file atomically to
+// release it, or to switch back to an older version.
+func VersionedSource(dir string) PluginSource {
+	return versionedSource{dir: dir}
+}
+
+func (vs versionedSource) List() ([]PluginEntry, error) {
+	var absDir string
+	if err := hutils.FindDirectory(vs.dir, "pluginDir"); err != nil {
+		return nil, err
+	} else if absDir, err = filepath.Abs(vs.dir); err != nil {
+		return nil, err
+	}
+
+	a, err := ioutil.ReadDir(absDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []PluginEntry
+	for _, fi := range a {
+		if !fi.IsDir() || fi.Name() == "tmp" {
+			continue
+		}
+		builds, err := vs.listBuilds(filepath.Join(absDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, builds...)
+	}
+	return entries, nil
+}
+
+func (vs versionedSource) listBuilds(dir string) ([]PluginEntry, error) {
+	var current string
+	currentFile := filepath.Join(dir, CurrentFileName)
+	if data, err := ioutil.ReadFile(currentFile); err == nil {
+		current = string(bytes.TrimSpace(data))
+		if current == "" {
+			return nil, fmt.Errorf("%s is empty", currentFile)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	a, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var builds []PluginEntry
+	var found bool
+	for _, fi := range a {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), hutils.FileNameExt) {
+			continue
+		}
+		version := strings.TrimSuffix(fi.Name(), hutils.FileNameExt)
+		builds = append(builds, PluginEntry{
+			Name:    filepath.Base(dir),
+			Path:    filepath.Join(dir, fi.Name()),
+			Version: version,
+			Current: version == current,
+		})
+		found = found || version == current
+	}
+	if current != "" && !found {
+		return nil, fmt.Errorf("the current version %s of %s is not found", current, filepath.Base(dir))
+	}
+	return builds, nil
+}
+
+func (vs versionedSource) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func (vs versionedSource) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+type contentAddressedSource struct {
+	dir string
+}
+
+// ContentAddressedSource returns a PluginSource reading a local content-addressed store.
+// <dir>/objects/<sha256> holds the content of a plugin file, and <dir>/refs/<name> holds the
+// hex-encoded SHA-256 of the plugin to load. Objects are immutable, so releasing or switching
+// back only rewrites refs. The content of an object is verified against its name.
+func ContentAddressedSource(dir string) PluginSource {
+	return contentAddressedSource{dir: dir}
+}
+
+func (cas contentAddressedSource) List() ([]PluginEntry, error) {
+	var absDir string
+	if err := hutils.FindDirectory(cas.dir, "pluginDir"); err != nil {
+		return nil, err
+	} else if absDir, err = filepath.Abs(cas.dir); err != nil {
+		return nil, err
+	}
+
+	refsDir := filepath.Join(absDir, "refs")
+	a, err := ioutil.ReadDir(refsDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []PluginEntry
+	for _, fi := range a {
+		if fi.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(refsDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := strings.ToLower(string(bytes.TrimSpace(data)))
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid ref %s: %q", fi.Name(), sum)
+		}
+		entries = append(entries, PluginEntry{
+			Name: fi.Name(),
+			Path: filepath.Join(absDir, "objects", sum),
+		})
+	}
+	return entries, nil
+}
+
+func (cas contentAddressedSource) ReadFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, hutils.SignatureExt) {
+		return data, nil
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != filepath.Base(path) {
+		return nil, errors.New("corrupted object: " + path)
+	}
+	return data, nil
+}
+
+// StoreObject stores data into the content-addressed store at dir and returns its SHA-256,
+// which can then be written into <dir>/refs/<name>.
+func StoreObject(dir string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	objectsDir := filepath.Join(dir, "objects")
+	if err := os.MkdirAll(objectsDir, 0755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(objectsDir, hash)
+	if _, err := os.Stat(dst); err == nil {
+		return hash, nil
+	}
+	tmp, err := ioutil.TempFile(objectsDir, hash+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,186 @@
+package hotswap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPluginSources(t *testing.T) {
+	fsys := fstest.MapFS{
+		"plugins/arya.so":     {Data: []byte("arya")},
+		"plugins/snow.so":     {Data: []byte("snow")},
+		"plugins/snow.so.sig": {Data: []byte("sig")},
+		"plugins/README":      {Data: []byte("readme")},
+	}
+	entries, err := FSSource(fsys, "plugins").List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1] != (PluginEntry{Name: "snow", Path: "plugins/snow.so"}) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	vdir := t.TempDir()
+	for _, f := range []string{"arya/1.0.0.so", "arya/1.1.0.so", "snow/2.0.0.so", "tmp/x.so"} {
+		if err := os.MkdirAll(filepath.Join(vdir, filepath.Dir(f)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(vdir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(vdir, "arya", CurrentFileName), []byte("1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = VersionedSource(vdir).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Path != filepath.Join(vdir, "arya", "1.0.0.so") ||
+		!entries[0].Current || entries[1].Current || entries[2].Current || entries[2].Version != "2.0.0" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	outputDir := preparePluginGroup(t, nil, "Source", "snow")
+	data1, err := os.ReadFile(filepath.Join(outputDir, "snow.so"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildArgs := []string{"--", "-ldflags", "-X main.CompileTimeString=stark"}
+	preparePluginGroupImpl(t, buildArgs, "Source", false, "snow")
+	data2, err := os.ReadFile(filepath.Join(outputDir, "snow.so"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := t.TempDir()
+	hash1, err := StoreObject(store, data1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash2, err := StoreObject(store, data2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setRef := func(hash string) {
+		if err := os.MkdirAll(filepath.Join(store, "refs"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(store, "refs", "snow"), []byte(hash+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setRef(hash1)
+
+	log := newScavenger()
+	swapper := newSwapper("", WithLogger(log), WithPluginSource(ContentAddressedSource(store)),
+		WithTempDir(t.TempDir()))
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if p := swapper.Current().FindPlugin("snow"); p == nil || p.File != filepath.Join(store, "objects", hash1) {
+		t.Fatalf("unexpected plugin: %+v", p)
+	}
+
+	setRef(hash2)
+	if _, err := swapper.Reload(log); err != nil {
+		t.Fatal(err)
+	}
+	var compileTimeString string
+	if err := swapper.Current().FindPlugin("snow").Lookup("CompileTimeString", &compileTimeString); err != nil {
+		t.Fatal(err)
+	} else if compileTimeString != "stark" {
+		t.Fatal("unexpected CompileTimeString: " + compileTimeString)
+	}
+
+	object := filepath.Join(store, "objects", hash1)
+	if err := os.WriteFile(object, data2, 0644); err != nil {
+		t.Fatal(err)
+	}
+	setRef(hash1)
+	if _, err := swapper.Reload(log); err == nil || !strings.Contains(err.Error(), "corrupted object") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestVersionPolicy(t *testing.T) {
+	builds := []PluginEntry{
+		{Name: "arya", Path: "arya/1.9.0.so", Version: "1.9.0", Current: true},
+		{Name: "arya", Path: "arya/1.10.0.so", Version: "1.10.0"},
+		{Name: "snow", Path: "snow/20220101.so", Version: "20220101"},
+		{Name: "snow", Path: "snow/20220102.so", Version: "20220102"},
+		{Name: "syrio", Path: "syrio.so"},
+	}
+	selected := func(policy VersionPolicy) string {
+		sw := newSwapper("", WithLogger(newScavenger()), WithVersionPolicy(policy))
+		entries, err := sw.selectVersions(builds)
+		if err != nil {
+			return err.Error()
+		}
+		var a []string
+		for _, e := range entries {
+			a = append(a, e.Path)
+		}
+		return strings.Join(a, ",")
+	}
+
+	if s := selected(nil); s != "no current build of snow is found" {
+		t.Fatalf("unexpected selection: %s", s)
+	}
+	if s := selected(NewestVersion(nil)); s != "arya/1.10.0.so,snow/20220102.so,syrio.so" {
+		t.Fatalf("unexpected selection: %s", s)
+	}
+	passed := map[string]bool{"arya/1.9.0.so": true, "snow/20220101.so": true}
+	if s := selected(NewestVersion(func(e PluginEntry) bool { return passed[e.Path] })); s != "arya/1.9.0.so,snow/20220101.so,syrio.so" {
+		t.Fatalf("unexpected selection: %s", s)
+	}
+	if s := selected(PinnedVersions(map[string]string{"Snow": "20220101"}, nil)); s != "arya/1.9.0.so,snow/20220101.so,syrio.so" {
+		t.Fatalf("unexpected selection: %s", s)
+	}
+	if s := selected(PinnedVersions(map[string]string{"arya": "2.0.0"}, nil)); s != "the pinned version 2.0.0 of arya is not found" {
+		t.Fatalf("unexpected selection: %s", s)
+	}
+
+	outputDir := preparePluginGroup(t, nil, "VersionPolicy", "snow")
+	data, err := os.ReadFile(filepath.Join(outputDir, "snow.so"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(vdir, "snow"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"1.0.0.so":      data,
+		"1.1.0.so":      []byte("not validated yet"),
+		CurrentFileName: []byte("1.1.0"),
+	}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(vdir, "snow", name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log := newScavenger()
+	policy := PinnedVersions(map[string]string{"snow": "1.0.0"}, nil)
+	swapper := newSwapper("", WithLogger(log), WithPluginSource(VersionedSource(vdir)),
+		WithVersionPolicy(policy), WithTempDir(t.TempDir()))
+	prepareEnv(t, "")
+	details, err := swapper.LoadPlugins(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.String() != "snow@1.0.0: ok" {
+		t.Fatalf("unexpected details: %s", details)
+	}
+	if p := swapper.Current().FindPlugin("snow"); p.Version != "1.0.0" {
+		t.Fatalf("unexpected plugin: %+v", p)
+	}
+	if pr := swapper.Current().Report().Plugins[0]; pr.Version != "1.0.0" {
+		t.Fatalf("unexpected plugin report: %+v", pr)
+	}
+}
@@ -1,6 +1,7 @@
 package hotswap
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -12,6 +13,8 @@ import (
 	"github.com/edwingeng/hotswap/vault"
 )
 
+// NewPluginFuncs returns the functions of a static plugin. The optional functions are set
+// with opts, e.g. WithOnMigrate(OnMigrate).
 func NewPluginFuncs(
 	fExport func() interface{},
 	hotswapLiveFuncs func() map[string]interface{},
@@ -22,8 +25,9 @@ func NewPluginFuncs(
 	fOnInit func(sharedVault *vault.Vault) error,
 	fOnLoad func(data interface{}) error,
 	fReloadable func() bool,
+	opts ...PluginFuncsOption,
 ) PluginFuncs {
-	return PluginFuncs{
+	pf := PluginFuncs{
 		fOnLoad:          fOnLoad,
 		fOnInit:          fOnInit,
 		fOnFree:          fOnFree,
@@ -34,6 +38,129 @@ func NewPluginFuncs(
 		hotswapLiveFuncs: hotswapLiveFuncs,
 		hotswapLiveTypes: hotswapLiveTypes,
 	}
+	for _, opt := range opts {
+		opt(&pf)
+	}
+	return pf
+}
+
+// NewStaticPlugin returns a StaticPlugin whose functions do nothing, except for Reloadable,
+// which returns true, and the ones set by opts.
+func NewStaticPlugin(name string, opts ...PluginFuncsOption) *StaticPlugin {
+	return &StaticPlugin{
+		Name: name,
+		PluginFuncs: NewPluginFuncs(
+			func() interface{} { return nil },
+			func() map[string]interface{} { return map[string]interface{}{} },
+			func() map[string]func() interface{} { return map[string]func() interface{}{} },
+			func() interface{} { return nil },
+			func(name string, params ...interface{}) (interface{}, error) { return nil, nil },
+			func() {},
+			func(sharedVault *vault.Vault) error { return nil },
+			func(data interface{}) error { return nil },
+			func() bool { return true },
+			opts...,
+		),
+	}
+}
+
+// PluginFuncsOption sets a function of a static plugin.
+type PluginFuncsOption func(pf *PluginFuncs)
+
+func WithOnLoad(f func(data interface{}) error) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fOnLoad = f
+	}
+}
+
+func WithOnInit(f func(sharedVault *vault.Vault) error) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fOnInit = f
+	}
+}
+
+func WithOnFree(f func()) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fOnFree = f
+	}
+}
+
+func WithExport(f func() interface{}) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fExport = f
+	}
+}
+
+func WithImport(f func() interface{}) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fImport = f
+	}
+}
+
+func WithInvokeFunc(f func(name string, params ...interface{}) (interface{}, error)) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.InvokeFunc = f
+	}
+}
+
+func WithReloadable(f func() bool) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fReloadable = f
+	}
+}
+
+func WithHotswapLiveFuncs(f func() map[string]interface{}) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.hotswapLiveFuncs = f
+	}
+}
+
+func WithHotswapLiveTypes(f func() map[string]func() interface{}) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.hotswapLiveTypes = f
+	}
+}
+
+func WithOnMigrate(f func(old interface{}) (interface{}, error)) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fOnMigrate = f
+	}
+}
+
+func WithOnSnapshot(f func() (interface{}, error)) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fOnSnapshot = f
+	}
+}
+
+func WithOnLoadContext(f func(ctx context.Context, data interface{}) error) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fOnLoadContext = f
+	}
+}
+
+func WithOnInitContext(f func(ctx context.Context, sharedVault *vault.Vault) error) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fOnInitContext = f
+	}
+}
+
+func WithInvokeFuncContext(f func(ctx context.Context, name string, params ...interface{}) (interface{}, error)) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.InvokeFuncContext = f
+	}
+}
+
+func WithHotswapMethods(f func() map[string]interface{}) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.hotswapMethods = f
+	}
+}
+
+func WithHealth(f func() error) PluginFuncsOption {
+	return func(pf *PluginFuncs) {
+		pf.fHealth = f
+	}
 }
 
 type StaticPlugin struct {
@@ -78,6 +205,9 @@ func (pm *PluginManager) loadStaticPlugins(staticPlugins map[string]*StaticPlugi
 	if err := pm.initDeps(); err != nil {
 		return err
 	}
+	if err := pm.invokeEveryOnMigrate(nil); err != nil {
+		return err
+	}
 	if err := pm.invokeEveryOnLoad(data); err != nil {
 		return err
 	}
@@ -103,13 +233,14 @@ func (pm *PluginManager) loadStaticPlugin(sp *StaticPlugin) error {
 	var missing []string
 	for _, v := range a {
 		vv := reflect.ValueOf(v.fn)
-		if isNil(vv.Elem().Interface()) {
+		if !v.optional && isNil(vv.Elem().Interface()) {
 			missing = append(missing, v.symbol)
 		}
 	}
 	if len(missing) > 0 {
 		return fmt.Errorf("missing functions: %s", strings.Join(missing, ", "))
 	}
+	pm.guardCalls(p)
 
 	var err error
 	p.reloadable, err = p.invokeReloadable()
@@ -126,7 +257,10 @@ func (pm *PluginManager) loadStaticPlugin(sp *StaticPlugin) error {
 }
 
 func (sw *PluginManagerSwapper) loadStaticPlugins(data interface{}, cbs []ReloadCallback) (Details, error) {
-	newManager := newPluginManager(sw.Logger, sw.opts.newExt)
+	start := time.Now()
+	sw.emit(EventReloadStarted, "", start, nil)
+	newManager := sw.newPluginManager()
+	failedStep := trackFailedStep(newManager)
 	staticPlugins := sw.staticPlugins
 	if len(sw.opts.whitelist) > 0 {
 		staticPlugins = make(map[string]*StaticPlugin)
@@ -134,23 +268,38 @@ func (sw *PluginManagerSwapper) loadStaticPlugins(data interface{}, cbs []Reload
 			if p, ok := sw.staticPlugins[name]; ok {
 				staticPlugins[name] = p
 			} else {
-				return nil, fmt.Errorf("cannot find the static plugin %q", name)
+				err := fmt.Errorf("cannot find the static plugin %q", name)
+				sw.abortReload(newManager, start, failedStep(), err)
+				return nil, err
 			}
 		}
 	}
 
 	if err := newManager.loadStaticPlugins(staticPlugins, data); err != nil {
+		sw.abortReload(newManager, start, failedStep(), err)
 		return nil, err
 	}
+	newManager.report = newReloadReport(newManager, nil, sw.generation+1, start)
 	if err := invokeReloadCallbacks(cbs, newManager, nil); err != nil {
+		sw.abortReload(newManager, start, failedStep(), err)
 		return nil, err
 	}
-
-	result := make(map[string]string)
-	for k := range staticPlugins {
-		result[k] = "ok"
+	if err := sw.gateHealth(newManager); err != nil {
+		newManager.invokeEveryOnFree()
+		sw.abortReload(newManager, start, failedStep(), err)
+		return nil, err
 	}
+	if err := sw.prepareReload(newManager, nil); err != nil {
+		newManager.invokeEveryOnFree()
+		sw.abortReload(newManager, start, failedStep(), err)
+		return nil, err
+	}
+
+	sw.generation++
+	result := newManager.report.Details()
 
 	sw.current.Store(newManager)
+	sw.commitReload(newManager, nil)
+	sw.emit(EventReloadCommitted, "", start, nil)
 	return result, nil
 }
@@ -0,0 +1,85 @@
+package hotswap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/edwingeng/hotswap/internal/hutils"
+)
+
+func (pm *PluginManager) tempRoot(pluginDir string) string {
+	if pm.tempDir != "" {
+		return pm.tempDir
+	}
+	return filepath.Join(pluginDir, "tmp")
+}
+
+func (pm *PluginManager) removeActualFile(p *Plugin) {
+	if !pm.tempCleanup || p.actualFile == "" || p.borrowed {
+		return
+	}
+	if err := os.Remove(p.actualFile); err != nil && !os.IsNotExist(err) {
+		pm.Warnf("<hotswap:%s> failed to remove %s. err: %v", p.Name, p.actualFile, err)
+		return
+	}
+	// Fails silently until every plugin of the generation is removed.
+	_ = os.Remove(filepath.Dir(p.actualFile))
+}
+
+func (sw *PluginManagerSwapper) tempRoot() string {
+	switch {
+	case sw.opts.tempDir != "":
+		return sw.opts.tempDir
+	case sw.opts.source != nil:
+		return filepath.Join(os.TempDir(), "hotswap")
+	default:
+		return filepath.Join(sw.opts.pluginDir, "tmp")
+	}
+}
+
+// tempDirPid extracts the pid from a directory name created by newPluginManager, i.e.
+// <datetime>-<pid>, optionally followed by -<suffix> as in the names of private directories.
+func tempDirPid(name string) (int, bool) {
+	a := strings.SplitN(name, "-", 3)
+	if len(a) < 2 || len(a[0]) != len(hutils.CompactDateTimeFormat) {
+		return 0, false
+	}
+	if _, err := strconv.ParseUint(a[0], 10, 64); err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(a[1])
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, true
+}
+
+func (sw *PluginManagerSwapper) cleanTempDirs() {
+	root := sw.tempRoot()
+	a, err := ioutil.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			sw.Warnf("<hotswap> failed to read the temp directory %s. err: %v", root, err)
+		}
+		return
+	}
+	self := os.Getpid()
+	for _, fi := range a {
+		if !fi.IsDir() {
+			continue
+		}
+		pid, ok := tempDirPid(fi.Name())
+		if !ok || pid == self || hutils.ProcessAlive(pid) {
+			continue
+		}
+		dir := filepath.Join(root, fi.Name())
+		if err := os.RemoveAll(dir); err != nil {
+			sw.Warnf("<hotswap> failed to remove %s. err: %v", dir, err)
+			continue
+		}
+		sw.Infof("<hotswap> removed %s left by a dead process", dir)
+	}
+}
@@ -0,0 +1,71 @@
+package hotswap
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ValidationReport describes a validation. Go plugins cannot be unloaded, so the files listed
+// in Mapped stay mapped into the process. Every build opened by Validate, whether or not the
+// validation succeeds, is kept until a reload of byte-identical files reuses it instead of
+// opening it again. Under WithTempCleanup, the copies of the opened builds are removed right
+// after they are opened. The report is returned even if the validation fails.
+type ValidationReport struct {
+	Plugins   []string
+	Unchanged []string
+	Mapped    []string
+}
+
+// Validate checks the plugins in dir as a reload would, without invoking any OnLoad or OnInit
+// and without touching the current PluginManager. The plugins are compared with the current
+// ones, so unchanged and not reloadable plugins are not opened again.
+func (sw *PluginManagerSwapper) Validate(dir string, data interface{}) (*ValidationReport, error) {
+	if sw.staticPlugins != nil {
+		return nil, errors.New("running under static linking mode")
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	src := DirSource(dir)
+	entries, err := sw.listPlugins(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no plugin is found in " + dir)
+	}
+
+	pm := sw.newPluginManager()
+	pm.source = src
+	pm.dryRun = true
+	pm.emit = func(EventKind, string, time.Time, error) {}
+	err = pm.loadPluginsKeeping(entries, nil, sw.Current(), data)
+
+	r := &ValidationReport{}
+	for _, p := range pm.pluginMap {
+		if p.unchanged {
+			r.Unchanged = append(r.Unchanged, p.Name)
+			continue
+		}
+		r.Plugins = append(r.Plugins, p.Name)
+		if p.actualFile != "" {
+			r.Mapped = append(r.Mapped, p.actualFile)
+		}
+	}
+	sort.Strings(r.Plugins)
+	sort.Strings(r.Unchanged)
+	sort.Strings(r.Mapped)
+	return r, err
+}
+
+// dropPreopened forgets the builds preopened by Validate which are loaded by newManager,
+// whose plugins take over the files.
+func (sw *PluginManagerSwapper) dropPreopened(newManager *PluginManager) {
+	for _, p := range newManager.pluginMap {
+		if p.borrowed {
+			delete(sw.preopened, p.FileSha1)
+			p.borrowed = false
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package hotswap
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPluginManagerSwapper_Validate(t *testing.T) {
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "Validate", pluginNames...)
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log))
+	prepareEnv(t, "")
+	r1, err := swapper.Validate(outputDir, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(r1.Plugins, ",") != "arya,snow" || len(r1.Unchanged) != 0 || len(r1.Mapped) != 2 {
+		t.Fatalf("unexpected validation report: %+v", r1)
+	}
+	if swapper.Current() != nil {
+		t.Fatal("Validate should not touch the current PluginManager")
+	}
+	if log.StringExists("invoking arya.OnLoad") || log.StringExists("invoking arya.OnInit") {
+		t.Fatal("Validate should not invoke OnLoad or OnInit")
+	}
+
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if !log.StringExists("reusing the plugin arya opened during validation") {
+		t.Fatal("the plugins opened during validation should be reused")
+	}
+
+	var events int
+	unsubscribe := swapper.Subscribe(func(Event) {
+		events++
+	})
+	defer unsubscribe()
+
+	preparePluginGroupImpl(t, nil, "Validate", false, "snow")
+	r2, err := swapper.Validate(outputDir, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(r2.Plugins, ",") != "snow" || strings.Join(r2.Unchanged, ",") != "arya" {
+		t.Fatalf("unexpected validation report: %+v", r2)
+	}
+	if swapper.Current().FindPlugin("arya").Refs.Load() != 1 {
+		t.Fatal("Validate should not leak references")
+	}
+
+	preparePluginGroupImpl(t, nil, "Validate", false, "mismatch1")
+	if _, err := swapper.Validate(outputDir, log); err == nil {
+		t.Fatal("Validate should fail when the plugins are incompatible")
+	} else if !strings.Contains(err.Error(), "is not assignable") {
+		t.Fatal("unexpected error: " + err.Error())
+	}
+	var names []string
+	for _, p := range swapper.preopened {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "mismatch1,snow" {
+		t.Fatalf("every opened build should be kept. preopened: %v", names)
+	}
+	if events != 0 {
+		t.Fatalf("Validate should not emit any event. events: %d", events)
+	}
+}
+
+func TestPluginManagerSwapper_Validate_reloadOlderBuild(t *testing.T) {
+	outputDir := preparePluginGroup(t, nil, "ValidateOlder", "arya")
+	file := completePluginPaths(outputDir, "arya")[0]
+	buildA, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log))
+	prepareEnv(t, "")
+	if _, err := swapper.Validate(outputDir, log); err != nil {
+		t.Fatal(err)
+	}
+	preparePluginGroupImpl(t, nil, "ValidateOlder", false, "arya")
+	if _, err := swapper.Validate(outputDir, log); err != nil {
+		t.Fatal(err)
+	}
+	if len(swapper.preopened) != 2 {
+		t.Fatalf("both builds should be kept. len(swapper.preopened): %d", len(swapper.preopened))
+	}
+
+	if err := os.WriteFile(file, buildA, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if !log.StringExists("reusing the plugin arya opened during validation") {
+		t.Fatal("the first build should be reused")
+	}
+	if len(swapper.preopened) != 1 {
+		t.Fatalf("the loaded build should be dropped. len(swapper.preopened): %d", len(swapper.preopened))
+	}
+}
+
+func TestPluginManagerSwapper_Validate_tempCleanup(t *testing.T) {
+	pluginNames := []string{"arya", "snow"}
+	outputDir := preparePluginGroup(t, nil, "ValidateCleanup", pluginNames...)
+
+	log := newScavenger()
+	tempDir := t.TempDir()
+	swapper := newSwapper(outputDir, WithLogger(log), WithTempDir(tempDir), WithTempCleanup())
+	prepareEnv(t, "")
+	r, err := swapper.Validate(outputDir, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Mapped) != 2 {
+		t.Fatalf("unexpected validation report: %+v", r)
+	}
+	for _, file := range r.Mapped {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Fatal("the copy opened during validation should be removed: " + file)
+		}
+	}
+
+	preparePluginGroupImpl(t, nil, "ValidateCleanup", false, "mismatch1")
+	if _, err := swapper.Validate(outputDir, log); err == nil {
+		t.Fatal("Validate should fail when the plugins are incompatible")
+	}
+	if a, err := os.ReadDir(tempDir); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 0 {
+		t.Fatalf("the temp directory should be empty. found: %s", a[0].Name())
+	}
+
+	if err := os.Remove(completePluginPaths(outputDir, "mismatch1")[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+	if !log.StringExists("reusing the plugin arya opened during validation") {
+		t.Fatal("the plugins opened during validation should be reused")
+	}
+}
@@ -1,5 +1,14 @@
 package vault
 
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// NamespaceSeparator joins a plugin name and a live symbol name under the namespaced mode.
+const NamespaceSeparator = "/"
+
 type Vault struct {
 	LiveFuncs map[string]interface{}
 	LiveTypes map[string]func() interface{}
@@ -7,3 +16,101 @@ type Vault struct {
 	DataBag   map[string]interface{}
 	Extension interface{}
 }
+
+func nameOf[T any]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
+
+func findSymbol[V any](m map[string]V, pluginName, name string) (V, bool) {
+	if pluginName != "" {
+		if x, ok := m[pluginName+NamespaceSeparator+name]; ok {
+			return x, true
+		}
+	}
+	if x, ok := m[name]; ok {
+		return x, true
+	}
+
+	var found V
+	var n int
+	suffix := NamespaceSeparator + name
+	for k, x := range m {
+		if strings.HasSuffix(k, suffix) {
+			found = x
+			n++
+		}
+	}
+	return found, n == 1
+}
+
+// FindFunc looks for a live function. It tries pluginName/name first, then name, and then
+// the only live function named name in any plugin.
+func (v *Vault) FindFunc(pluginName, name string) (interface{}, bool) {
+	return findSymbol(v.LiveFuncs, pluginName, name)
+}
+
+// FindType looks for a live type in the same order as FindFunc.
+func (v *Vault) FindType(pluginName, name string) (func() interface{}, bool) {
+	return findSymbol(v.LiveTypes, pluginName, name)
+}
+
+// Func returns the live function with the specified name as a T.
+func Func[T any](v *Vault, name string) (T, error) {
+	var zero T
+	fn, ok := v.LiveFuncs[name]
+	if !ok {
+		return zero, fmt.Errorf("unknown live function: %s", name)
+	}
+	return castFunc[T](fn, name)
+}
+
+// PluginFunc is similar to Func but resolves the name with FindFunc.
+func PluginFunc[T any](v *Vault, pluginName, name string) (T, error) {
+	var zero T
+	fn, ok := v.FindFunc(pluginName, name)
+	if !ok {
+		return zero, fmt.Errorf("unknown live function: %s. plugin: %s", name, pluginName)
+	}
+	return castFunc[T](fn, name)
+}
+
+func castFunc[T any](fn interface{}, name string) (T, error) {
+	var zero T
+	f, ok := fn.(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected signature of the live function %s. expected: %s, actual: %T",
+			name, nameOf[T](), fn)
+	}
+	return f, nil
+}
+
+// New creates an object of the live type with the specified name and returns it as a T.
+func New[T any](v *Vault, typeName string) (T, error) {
+	var zero T
+	newObj, ok := v.LiveTypes[typeName]
+	if !ok {
+		return zero, fmt.Errorf("unknown live type: %s", typeName)
+	}
+	return newAs[T](newObj, typeName)
+}
+
+// PluginNew is similar to New but resolves the type name with FindType.
+func PluginNew[T any](v *Vault, pluginName, typeName string) (T, error) {
+	var zero T
+	newObj, ok := v.FindType(pluginName, typeName)
+	if !ok {
+		return zero, fmt.Errorf("unknown live type: %s. plugin: %s", typeName, pluginName)
+	}
+	return newAs[T](newObj, typeName)
+}
+
+func newAs[T any](newObj func() interface{}, typeName string) (T, error) {
+	var zero T
+	obj := newObj()
+	t, ok := obj.(T)
+	if !ok {
+		return zero, fmt.Errorf("the live type %s cannot be used as %s. actual: %T",
+			typeName, nameOf[T](), obj)
+	}
+	return t, nil
+}
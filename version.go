@@ -0,0 +1,152 @@
+package hotswap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// VersionPolicy selects the build to load when a PluginSource lists more than one build of a
+// plugin, e.g. VersionedSource. Select returns nil to skip the plugin.
+type VersionPolicy interface {
+	Select(name string, builds []PluginEntry) (*PluginEntry, error)
+}
+
+// VersionPolicyFunc is an adapter to allow the use of ordinary functions as VersionPolicy.
+type VersionPolicyFunc func(name string, builds []PluginEntry) (*PluginEntry, error)
+
+func (f VersionPolicyFunc) Select(name string, builds []PluginEntry) (*PluginEntry, error) {
+	return f(name, builds)
+}
+
+// CurrentVersion selects the build marked as current by the PluginSource. It is the default
+// VersionPolicy. A plugin without a current build is an error, unless it has only one build
+// and the build is unversioned.
+func CurrentVersion() VersionPolicy {
+	return VersionPolicyFunc(func(name string, builds []PluginEntry) (*PluginEntry, error) {
+		if len(builds) == 1 && builds[0].Version == "" {
+			return &builds[0], nil
+		}
+		var found *PluginEntry
+		for i := range builds {
+			if !builds[i].Current {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("more than one current build of %s: %s, %s",
+					name, found.Path, builds[i].Path)
+			}
+			found = &builds[i]
+		}
+		if found == nil {
+			return nil, fmt.Errorf("no current build of %s is found", name)
+		}
+		return found, nil
+	})
+}
+
+// PinnedVersions selects the pinned version of the plugins in pins, which maps plugin names
+// to versions. The other plugins are left to fallback, or to CurrentVersion if fallback is nil.
+func PinnedVersions(pins map[string]string, fallback VersionPolicy) VersionPolicy {
+	if fallback == nil {
+		fallback = CurrentVersion()
+	}
+	keyed := make(map[string]string)
+	for name, v := range pins {
+		keyed[name2key(name)] = v
+	}
+	return VersionPolicyFunc(func(name string, builds []PluginEntry) (*PluginEntry, error) {
+		v, ok := keyed[name2key(name)]
+		if !ok {
+			return fallback.Select(name, builds)
+		}
+		for i := range builds {
+			if builds[i].Version == v {
+				return &builds[i], nil
+			}
+		}
+		return nil, fmt.Errorf("the pinned version %s of %s is not found", v, name)
+	})
+}
+
+// NewestVersion selects the newest build accepted by filter. A nil filter accepts every build.
+// Versions are compared as semantic versions when both are valid, e.g. 1.2.0 or v1.2.0, and as
+// strings otherwise, which suits timestamps.
+func NewestVersion(filter func(e PluginEntry) bool) VersionPolicy {
+	return VersionPolicyFunc(func(name string, builds []PluginEntry) (*PluginEntry, error) {
+		var found *PluginEntry
+		for i := range builds {
+			if filter != nil && !filter(builds[i]) {
+				continue
+			}
+			if found == nil || compareVersions(builds[i].Version, found.Version) > 0 {
+				found = &builds[i]
+			}
+		}
+		return found, nil
+	})
+}
+
+func compareVersions(v1, v2 string) int {
+	sv1, sv2 := v1, v2
+	if !strings.HasPrefix(sv1, "v") {
+		sv1 = "v" + sv1
+	}
+	if !strings.HasPrefix(sv2, "v") {
+		sv2 = "v" + sv2
+	}
+	if semver.IsValid(sv1) && semver.IsValid(sv2) {
+		if c := semver.Compare(sv1, sv2); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(v1, v2)
+}
+
+func (sw *PluginManagerSwapper) selectVersions(all []PluginEntry) ([]PluginEntry, error) {
+	groups := make(map[string][]PluginEntry)
+	var keys []string
+	for _, e := range all {
+		k := name2key(e.Name)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], e)
+	}
+	sort.Strings(keys)
+
+	policy := sw.opts.versionPolicy
+	if policy == nil {
+		policy = CurrentVersion()
+	}
+	var entries []PluginEntry
+	for _, k := range keys {
+		builds := groups[k]
+		if unversioned(builds) {
+			// Duplicate names are rejected by loadPlugins.
+			entries = append(entries, builds...)
+			continue
+		}
+		e, err := policy.Select(builds[0].Name, builds)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			sw.Debugf("<hotswap> no build of %s is selected", builds[0].Name)
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	return entries, nil
+}
+
+func unversioned(builds []PluginEntry) bool {
+	for _, e := range builds {
+		if e.Version != "" {
+			return false
+		}
+	}
+	return true
+}
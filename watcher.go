@@ -0,0 +1,141 @@
+package hotswap
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type ReloadResult struct {
+	Details Details
+	Err     error
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+type dirSnapshot map[string]fileStamp
+
+func (ds dirSnapshot) equal(another dirSnapshot) bool {
+	if len(ds) != len(another) {
+		return false
+	}
+	for k, v := range ds {
+		if w, ok := another[k]; !ok || w.size != v.size || !w.modTime.Equal(v.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+type Watcher struct {
+	sw    *PluginManagerSwapper
+	data  interface{}
+	extra ReloadCallback
+
+	results  chan ReloadResult
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Watch polls the plugin source and calls ReloadWithCallback once new or changed
+// plugin files have stayed untouched for the settle delay.
+func (sw *PluginManagerSwapper) Watch(data interface{}, extra ReloadCallback) (*Watcher, error) {
+	if sw.staticPlugins != nil {
+		return nil, errors.New("running under static linking mode")
+	}
+
+	w := &Watcher{
+		sw:      sw,
+		data:    data,
+		extra:   extra,
+		results: make(chan ReloadResult, 16),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	snapshot, err := sw.scanPluginDir()
+	if err != nil {
+		return nil, err
+	}
+	go w.run(snapshot)
+	return w, nil
+}
+
+func (w *Watcher) Results() <-chan ReloadResult {
+	return w.results
+}
+
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *Watcher) run(loaded dirSnapshot) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.sw.opts.watchInterval)
+	defer ticker.Stop()
+
+	prev := loaded
+	settledSince := time.Now()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		cur, err := w.sw.scanPluginDir()
+		if err != nil {
+			w.sw.Warnf("<hotswap> failed to scan the plugin directory. err: %v", err)
+			continue
+		}
+		if !cur.equal(prev) {
+			prev = cur
+			settledSince = time.Now()
+			continue
+		}
+		if cur.equal(loaded) || time.Since(settledSince) < w.sw.opts.settleDelay {
+			continue
+		}
+
+		loaded = cur
+		w.sw.Info("<hotswap> plugin changes detected, reloading...")
+		details, err := w.sw.ReloadWithCallback(w.data, w.extra)
+		select {
+		case w.results <- ReloadResult{Details: details, Err: err}:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (sw *PluginManagerSwapper) scanPluginDir() (dirSnapshot, error) {
+	src := sw.source()
+	all, err := src.List()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := sw.filterPlugins(all)
+	if err != nil {
+		return nil, err
+	}
+	st, _ := src.(statSource)
+	snapshot := make(dirSnapshot)
+	for _, e := range entries {
+		var stamp fileStamp
+		if st != nil {
+			fi, err := st.Stat(e.Path)
+			if err != nil {
+				return nil, err
+			}
+			stamp.size, stamp.modTime = fi.Size(), fi.ModTime()
+		}
+		snapshot[e.Path] = stamp
+	}
+	return snapshot, nil
+}
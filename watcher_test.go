@@ -0,0 +1,52 @@
+package hotswap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPluginManagerSwapper_Watch(t *testing.T) {
+	pluginNames := []string{"snow"}
+	outputDir := preparePluginGroup(t, nil, "Watch", pluginNames...)
+
+	log := newScavenger()
+	swapper := newSwapper(outputDir, WithLogger(log),
+		WithWatchInterval(time.Millisecond*50), WithSettleDelay(time.Millisecond*200))
+	prepareEnv(t, "")
+	if _, err := swapper.LoadPlugins(log); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := swapper.Watch(log, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	select {
+	case r := <-w.Results():
+		t.Fatalf("unexpected reload result: %+v", r)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	oldMgr := swapper.Current()
+	buildArgs := []string{"--", "-ldflags", "-X main.CompileTimeString=stark"}
+	preparePluginGroupImpl(t, buildArgs, "Watch", false, pluginNames...)
+	select {
+	case r := <-w.Results():
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if len(r.Details) != 1 {
+			t.Fatalf("unexpected details: %s", r.Details)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("Watch did not reload the changed plugin")
+	}
+	if swapper.Current() == oldMgr {
+		t.Fatal("swapper.Current() == oldMgr")
+	}
+	if swapper.ReloadCounter() != 1 {
+		t.Fatal("swapper.ReloadCounter() != 1")
+	}
+}